@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shauncampbell/tplink2mqtt/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with the configuration",
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:          "check",
+	Short:        "Check the configuration for problems",
+	SilenceUsage: true,
+	// The problems are printed by checkConfiguration, and the summary by main.
+	SilenceErrors: true,
+	RunE:          checkConfiguration,
+}
+
+// checkConfiguration reports every problem with the configuration, and fails if there are any.
+func checkConfiguration(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Read(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %w", err)
+	}
+
+	err = cfg.Validate()
+	var validationErr config.ValidationError
+	if errors.As(err, &validationErr) {
+		for _, problem := range validationErr {
+			cmd.PrintErrln(problem.Error())
+		}
+		return fmt.Errorf("configuration has %d problems", len(validationErr))
+	} else if err != nil {
+		return err
+	}
+
+	cmd.Println("configuration is valid")
+	return nil
+}
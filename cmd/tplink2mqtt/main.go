@@ -1,12 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/shauncampbell/tplink2mqtt/internal/listener"
+	"github.com/shauncampbell/tplink2mqtt/internal/listener/energy"
 	haListener "github.com/shauncampbell/tplink2mqtt/internal/listener/homeassistant"
+	standardListener "github.com/shauncampbell/tplink2mqtt/internal/listener/standard"
 
 	"github.com/shauncampbell/tplink2mqtt/internal/destination"
 	haDestination "github.com/shauncampbell/tplink2mqtt/internal/destination/homeassistant"
@@ -14,6 +19,8 @@ import (
 
 	"github.com/shauncampbell/tplink2mqtt/internal/config"
 	"github.com/shauncampbell/tplink2mqtt/internal/tplink2mqtt"
+	"github.com/shauncampbell/tplink2mqtt/internal/version"
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog/log"
@@ -21,33 +28,67 @@ import (
 )
 
 var rootCmd = &cobra.Command{
-	Use:  "tplink2mqtt",
-	RunE: runApplication,
+	Use:     "tplink2mqtt",
+	Version: version.Version,
+	RunE:    runApplication,
 }
 
+// configFile is the path to the configuration file, which is optional.
+var configFile string
+
 const defaultTimeout = 10 * time.Second
 
 func runApplication(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Read()
+	cfg, err := config.Read(configFile)
 
 	if err != nil {
 		return fmt.Errorf("failed to read configuration: %w", err)
 	}
+	if err = cfg.Validate(); err != nil {
+		return err
+	}
+	log.Info().Msgf("starting tplink2mqtt %s", version.Version)
 
-	handler := tplink2mqtt.New(cfg,
+	// The handler owns the configuration, so the entity options are looked up through it once it has been created.
+	var handler *tplink2mqtt.Handler
+	handler = tplink2mqtt.New(cfg,
 		[]destination.Destination{
 			standard.New(standard.Options{}),
-			haDestination.New(haDestination.Options{}),
+			haDestination.New(haDestination.Options{
+				BridgeStateTopic: tplink2mqtt.BridgeStateTopic,
+				EntityOptions: func(device *tplinkModel.Device) config.HomeAssistantOptions {
+					return handler.HomeAssistantOptions(device)
+				},
+			}),
 		},
 		[]listener.Listener{
 			haListener.New(haListener.Options{
 				Timeout: cfg.Timeout,
 				Subnet:  cfg.Subnet,
 			}),
+			standardListener.New(standardListener.Options{
+				Timeout: cfg.Timeout,
+				Subnet:  cfg.Subnet,
+			}),
+			energy.New(energy.Options{
+				Timeout: cfg.Timeout,
+				Subnet:  cfg.Subnet,
+			}),
 		})
 
 	mqttOptions := mqtt.NewClientOptions()
-	mqttOptions.AddBroker(fmt.Sprintf("tcp://%s:%d", cfg.MQTT.Host, cfg.MQTT.Port))
+	mqttOptions.AddBroker(cfg.MQTT.BrokerURL())
+	if cfg.MQTT.IsSecure() {
+		var tlsConfig *tls.Config
+		tlsConfig, err = cfg.MQTT.TLSConfig()
+		if err != nil {
+			return fmt.Errorf("failed to configure tls: %w", err)
+		}
+		mqttOptions.SetTLSConfig(tlsConfig)
+	}
+	if cfg.MQTT.IsWebsocket() {
+		mqttOptions.SetHTTPHeaders(cfg.MQTT.HTTPHeaders())
+	}
 	if cfg.MQTT.Username != "" {
 		mqttOptions.Username = cfg.MQTT.Username
 	}
@@ -55,6 +96,7 @@ func runApplication(cmd *cobra.Command, args []string) error {
 		mqttOptions.Password = cfg.MQTT.Password
 	}
 	mqttOptions.SetClientID("tplink2mqtt")
+	mqttOptions.SetWill(tplink2mqtt.BridgeStateTopic, destination.Offline, 1, true)
 	mqttOptions.OnConnect = handler.Connected
 	mqttOptions.OnConnectionLost = handler.Disconnected
 	mqttClient := mqtt.NewClient(mqttOptions)
@@ -63,18 +105,61 @@ func runApplication(cmd *cobra.Command, args []string) error {
 		return token.Error()
 	}
 
+	// Changes to the configuration file, and SIGHUP, reload the configuration without restarting.
+	reloads := make(chan struct{}, 1)
+	if configFile != "" {
+		err = config.Watch(configFile, func() {
+			select {
+			case reloads <- struct{}{}:
+			default:
+				// A reload is already pending.
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	ticker := time.NewTicker(defaultTimeout)
+	defer ticker.Stop()
 	for {
-		time.Sleep(defaultTimeout)
-		if !mqttClient.IsConnected() {
-			log.Error().Msg("connection to mqtt was severed")
-			break
+		select {
+		case <-signals:
+			log.Info().Msg("received SIGHUP, reloading configuration")
+			reloadConfiguration(handler, mqttClient)
+		case <-reloads:
+			log.Info().Msgf("%s has changed, reloading configuration", configFile)
+			reloadConfiguration(handler, mqttClient)
+		case <-ticker.C:
+			if !mqttClient.IsConnected() {
+				log.Error().Msg("connection to mqtt was severed")
+				return err
+			}
 		}
 	}
+}
 
-	return err
+// reloadConfiguration reads the configuration again and applies it to the handler. If the configuration can't be
+// read then the current configuration is kept.
+func reloadConfiguration(handler *tplink2mqtt.Handler, client mqtt.Client) {
+	cfg, err := config.Read(configFile)
+	if err != nil {
+		log.Error().Msgf("failed to reload configuration, keeping the current configuration: %s", err.Error())
+		return
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Error().Msgf("keeping the current configuration: %s", err.Error())
+		return
+	}
+	handler.Reload(cfg, client)
 }
 
 func main() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "path to a yaml or toml configuration file")
+	configCmd.AddCommand(configCheckCmd)
+	rootCmd.AddCommand(configCmd)
 	if err := rootCmd.Execute(); err != nil {
 		log.Error().Msgf("unable to run application: %s", err.Error())
 		if _, e := fmt.Fprintf(os.Stderr, "unable to run application: %s\n", err.Error()); e != nil {
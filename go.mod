@@ -3,9 +3,9 @@ module github.com/shauncampbell/tplink2mqtt
 go 1.16
 
 require (
-	github.com/eclipse/paho.mqtt.golang v1.3.5 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/rs/zerolog v1.23.0
-	github.com/shauncampbell/golang-tplink-hs100 v0.5.2
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/viper v1.8.1
 	golang.org/x/net v0.0.0-20210716203947-853a461950ff // indirect
@@ -3,43 +3,258 @@ package config
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 )
 
 // Config is a struct which contains the configuration for the application.
 type Config struct {
-	MQTT struct {
-		Host     string `mapstructure:"host"`
-		Port     int    `mapstructure:"port"`
-		Username string `mapstructure:"username"`
-		Password string `mapstructure:"password"`
-	} `mapstructure:"mqtt"`
-	Subnet   string `mapstructure:"subnet"`
-	Timeout  int    `mapstructure:"timeout"`
-	Interval int    `mapstructure:"interval"`
-}
-
-// Read reads in the configuration from the environment.
-func Read() (*Config, error) {
+	MQTT    MQTTOptions `mapstructure:"mqtt" json:"mqtt"`
+	Subnet  string      `mapstructure:"subnet" json:"subnet"`
+	Timeout int         `mapstructure:"timeout" json:"timeout"`
+	// Interval is the number of seconds between polls of the state of each device.
+	Interval  int    `mapstructure:"interval" json:"interval"`
+	Discovery string `mapstructure:"discovery" json:"discovery"`
+	Broadcast string `mapstructure:"broadcast" json:"broadcast"`
+	// DiscoveryInterval is the number of seconds between discoveries of new devices.
+	DiscoveryInterval int `mapstructure:"discovery_interval" json:"discovery_interval"`
+	// Workers is the number of devices which are polled at once.
+	Workers int `mapstructure:"workers" json:"workers"`
+	// MissedPolls is the number of polls a device can be missing from before it is marked as offline.
+	MissedPolls int `mapstructure:"missed_polls" json:"missed_polls"`
+	// RepublishInterval is the number of seconds after which a device is published again even if it hasn't changed.
+	// Zero means devices are only published when they change.
+	RepublishInterval int `mapstructure:"republish_interval" json:"republish_interval"`
+	// Deadband holds the smallest change to a numeric attribute, keyed by property, which causes the device to be
+	// published again.
+	Deadband map[string]Deadband `mapstructure:"deadband" json:"deadband"`
+	// OfflineExpiry is the number of seconds a device can be offline for before its retained topics are cleared.
+	// Zero means devices never expire.
+	OfflineExpiry int `mapstructure:"offline_expiry" json:"offline_expiry"`
+	// Devices holds the options for individual devices keyed by device id.
+	Devices map[string]DeviceOptions `mapstructure:"devices" json:"devices"`
+}
+
+// redacted replaces secrets in the configuration when it is published.
+const redacted = "********"
+
+// Redacted returns a copy of the configuration with secrets such as passwords replaced, so that it can be published.
+func (c *Config) Redacted() Config {
+	r := *c
+	if r.MQTT.Password != "" {
+		r.MQTT.Password = redacted
+	}
+	// Headers often hold credentials for a proxy, so their values are redacted too.
+	r.MQTT.Headers = make(map[string]string, len(c.MQTT.Headers))
+	for name := range c.MQTT.Headers {
+		r.MQTT.Headers[name] = redacted
+	}
+	r.Deadband = make(map[string]Deadband, len(c.Deadband))
+	for property, deadband := range c.Deadband {
+		r.Deadband[property] = deadband
+	}
+	r.Devices = make(map[string]DeviceOptions, len(c.Devices))
+	for id, options := range c.Devices {
+		r.Devices[id] = options
+	}
+	return r
+}
+
+// MQTTOptions are the options for connecting to the broker.
+type MQTTOptions struct {
+	// Scheme is the scheme of the broker url, which is one of tcp, ssl, ws or wss.
+	Scheme   string     `mapstructure:"scheme" json:"scheme"`
+	Host     string     `mapstructure:"host" json:"host"`
+	Port     int        `mapstructure:"port" json:"port"`
+	Username string     `mapstructure:"username" json:"username"`
+	Password string     `mapstructure:"password" json:"password"`
+	TLS      TLSOptions `mapstructure:"tls" json:"tls"`
+	// Path is the path of the websocket endpoint of the broker, which is only used with the ws and wss schemes. It
+	// is /mqtt by default.
+	Path string `mapstructure:"path" json:"path,omitempty"`
+	// Headers are sent when connecting with the ws and wss schemes, e.g. to authenticate with a reverse proxy.
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty"`
+}
+
+// TLSOptions are the options for connecting to the broker with the ssl or wss schemes.
+type TLSOptions struct {
+	// CA is the path to a bundle of pem encoded certificates which the broker is verified with. The system
+	// certificates are used if it isn't set.
+	CA string `mapstructure:"ca" json:"ca,omitempty"`
+	// Cert and Key are the paths to the pem encoded client certificate and its private key, which are needed when
+	// the broker requires mutual tls.
+	Cert string `mapstructure:"cert" json:"cert,omitempty"`
+	Key  string `mapstructure:"key" json:"key,omitempty"`
+	// ServerName overrides the name which the certificate of the broker is verified against, which is the host by
+	// default.
+	ServerName string `mapstructure:"server_name" json:"server_name,omitempty"`
+	// InsecureSkipVerify disables verification of the certificate of the broker. It should only be used for testing.
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+// DeviceOptions are the options which can be set for an individual device.
+type DeviceOptions struct {
+	// FriendlyName overrides the alias which is set on the device.
+	FriendlyName string `mapstructure:"friendly_name" json:"friendly_name,omitempty"`
+	// Ignore stops the device from being published.
+	Ignore bool `mapstructure:"ignore" json:"ignore,omitempty"`
+	// PollInterval overrides the number of seconds between polls of the state of the device.
+	PollInterval int `mapstructure:"poll_interval" json:"poll_interval,omitempty"`
+	// HomeAssistant customises the entity which is created for the device in home assistant.
+	HomeAssistant HomeAssistantOptions `mapstructure:"homeassistant" json:"homeassistant,omitempty"`
+}
+
+// HomeAssistantOptions customise the entity which is created for a device in home assistant.
+type HomeAssistantOptions struct {
+	// Name overrides the name of the entity, which is the friendly name of the device by default.
+	Name string `mapstructure:"name" json:"name,omitempty"`
+	// Icon is the icon of the entity, e.g. mdi:lamp.
+	Icon string `mapstructure:"icon" json:"icon,omitempty"`
+	// DeviceClass is the device class of the entity, e.g. outlet or switch.
+	DeviceClass string `mapstructure:"device_class" json:"device_class,omitempty"`
+	// EnabledByDefault can be set to false to add the entity to home assistant disabled.
+	EnabledByDefault *bool `mapstructure:"enabled_by_default" json:"enabled_by_default,omitempty"`
+}
+
+// Device returns the options for the device with the specified id or mac address, along with the key they are
+// stored under. Keys are matched regardless of case, separators or a 0x prefix, so the device id can be given as
+// it is reported by the device or as it is published. The mac address is ignored if it is empty.
+func (c *Config) Device(id, mac string) (string, DeviceOptions, bool) {
+	if options, ok := c.Devices[id]; ok {
+		return id, options, true
+	}
+	id, mac = normalizeDeviceKey(id), normalizeDeviceKey(mac)
+	for key, options := range c.Devices {
+		normalized := normalizeDeviceKey(key)
+		if normalized == id || (mac != "" && normalized == mac) {
+			return key, options, true
+		}
+	}
+	return "", DeviceOptions{}, false
+}
+
+// normalizeDeviceKey converts a device id or mac address into the form which is used to compare them.
+func normalizeDeviceKey(key string) string {
+	key = strings.ToLower(key)
+	key = strings.TrimPrefix(key, "0x")
+	return strings.NewReplacer(":", "", "-", "").Replace(key)
+}
+
+// Deadband is the smallest change to a numeric attribute which is published. When both thresholds are set the change
+// must exceed both of them.
+type Deadband struct {
+	// Absolute is the smallest change in the unit of the attribute.
+	Absolute float64 `mapstructure:"absolute" json:"absolute,omitempty"`
+	// Percent is the smallest change as a percentage of the last published value.
+	Percent float64 `mapstructure:"percent" json:"percent,omitempty"`
+}
+
+// Read reads in the configuration from the file at the specified path, if there is one, and the environment.
+// Environment variables override the settings in the file. The format of the file is determined by its extension,
+// e.g. .yaml or .toml.
+func Read(path string) (*Config, error) {
+	// A new instance is used every time, so that reading again while a previous configuration is in use is safe.
+	v := viper.New()
 	// MQTT config options
-	viper.SetDefault("mqtt.host", "")
-	viper.SetDefault("mqtt.port", 1883)
-	viper.SetDefault("mqtt.username", "")
-	viper.SetDefault("mqtt.password", "")
-	viper.SetDefault("subnet", "192.168.2.0/24")
-	viper.SetDefault("timeout", 5)
-	viper.SetDefault("interval", 30)
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	viper.SetEnvPrefix("TPLINK")
-	viper.AutomaticEnv()
+	v.SetDefault("mqtt.scheme", "tcp")
+	v.SetDefault("mqtt.host", "")
+	v.SetDefault("mqtt.port", 1883)
+	v.SetDefault("mqtt.username", "")
+	v.SetDefault("mqtt.password", "")
+	v.SetDefault("mqtt.tls.ca", "")
+	v.SetDefault("mqtt.tls.cert", "")
+	v.SetDefault("mqtt.tls.key", "")
+	v.SetDefault("mqtt.tls.server_name", "")
+	v.SetDefault("mqtt.tls.insecure_skip_verify", false)
+	v.SetDefault("mqtt.path", "")
+	v.SetDefault("subnet", "192.168.2.0/24")
+	v.SetDefault("timeout", 5)
+	v.SetDefault("interval", 30)
+	v.SetDefault("discovery", "both")
+	v.SetDefault("broadcast", "255.255.255.255")
+	v.SetDefault("discovery_interval", 300)
+	v.SetDefault("workers", 16)
+	v.SetDefault("missed_polls", 3)
+	v.SetDefault("offline_expiry", 0)
+	v.SetDefault("republish_interval", 600)
+	// Energy readings jitter slightly and the energy counter creeps up on every poll, so small changes aren't
+	// published by default.
+	v.SetDefault("deadband.voltage.absolute", 1)
+	v.SetDefault("deadband.voltage.percent", 0)
+	v.SetDefault("deadband.current.absolute", 0.01)
+	v.SetDefault("deadband.current.percent", 0)
+	v.SetDefault("deadband.power.absolute", 1)
+	v.SetDefault("deadband.power.percent", 0)
+	v.SetDefault("deadband.total_energy.absolute", 0.01)
+	v.SetDefault("deadband.total_energy.percent", 0)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.SetEnvPrefix("TPLINK")
+	v.AutomaticEnv()
+
+	if path != "" {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("unable to read configuration file: %w", err)
+		}
+	}
 
 	var config Config
-	err := viper.Unmarshal(&config)
+	err := v.Unmarshal(&config)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal configuration: %w", err)
 	}
+	if config.Deadband == nil {
+		config.Deadband = make(map[string]Deadband)
+	}
+	if config.Devices == nil {
+		config.Devices = make(map[string]DeviceOptions)
+	}
 
 	return &config, nil
 }
+
+// Watch calls onChange whenever the configuration file at path changes. It only signals the change, so the
+// configuration has to be read again with Read.
+func Watch(path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch configuration file: %w", err)
+	}
+
+	// The directory is watched rather than the file, so that editors which save by replacing the file, and mounted
+	// files which are updated by replacing a symlink, are still seen.
+	file := filepath.Clean(path)
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		_ = watcher.Close()
+		return fmt.Errorf("unable to watch configuration file: %w", err)
+	}
+	target, _ := filepath.EvalSymlinks(file)
+
+	go func() {
+		defer func() { _ = watcher.Close() }()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if written || (current != "" && current != target) {
+					target = current
+					onChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Msgf("error watching configuration file: %s", err.Error())
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// defaultWebsocketPath is the path of the websocket endpoint used by most brokers.
+const defaultWebsocketPath = "/mqtt"
+
+// IsSecure returns true if the connection to the broker uses tls.
+func (m *MQTTOptions) IsSecure() bool {
+	return m.Scheme == "ssl" || m.Scheme == "wss"
+}
+
+// IsWebsocket returns true if the connection to the broker is made over websockets.
+func (m *MQTTOptions) IsWebsocket() bool {
+	return m.Scheme == "ws" || m.Scheme == "wss"
+}
+
+// BrokerURL returns the url of the broker which is passed to the mqtt client.
+func (m *MQTTOptions) BrokerURL() string {
+	if !m.IsWebsocket() {
+		return fmt.Sprintf("%s://%s:%d", m.Scheme, m.Host, m.Port)
+	}
+	path := m.Path
+	if path == "" {
+		path = defaultWebsocketPath
+	}
+	return fmt.Sprintf("%s://%s:%d%s", m.Scheme, m.Host, m.Port, path)
+}
+
+// HTTPHeaders returns the headers which are sent when connecting over websockets.
+func (m *MQTTOptions) HTTPHeaders() http.Header {
+	headers := make(http.Header, len(m.Headers))
+	for name, value := range m.Headers {
+		headers.Set(name, value)
+	}
+	return headers
+}
+
+// TLSConfig returns the tls configuration for connecting to the broker, which loads the ca bundle and client
+// certificate from disk.
+func (m *MQTTOptions) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         m.TLS.ServerName,
+		InsecureSkipVerify: m.TLS.InsecureSkipVerify, //nolint:gosec // verification is only skipped when asked to
+	}
+
+	if m.TLS.CA != "" {
+		b, err := ioutil.ReadFile(m.TLS.CA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ca bundle: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in ca bundle %s", m.TLS.CA)
+		}
+	}
+
+	if m.TLS.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(m.TLS.Cert, m.TLS.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	tplinkClient "github.com/shauncampbell/tplink2mqtt/internal/tplink"
+	"github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+const maxPort = 65535
+
+var (
+	deviceIDRegex   = regexp.MustCompile(`^(0x)?[0-9a-f]+$`)
+	macAddressRegex = regexp.MustCompile(`^([0-9a-f]{2}[:-]){5}[0-9a-f]{2}$`)
+	// headerNameRegex matches the characters which are allowed in the name of an http header.
+	headerNameRegex = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+)
+
+// discoveryModes are the valid values of the discovery setting. They match the modes of the tplink package.
+var discoveryModes = []string{"broadcast", "sweep", "both"}
+
+// mqttSchemes are the valid schemes of the broker url.
+var mqttSchemes = []string{"tcp", "ssl", "ws", "wss"}
+
+// deadbandProperties are the numeric attributes which a deadband can be set for.
+var deadbandProperties = []string{
+	tplink.VoltageDeviceAttribute.Property,
+	tplink.CurrentDeviceAttribute.Property,
+	tplink.PowerDeviceAttribute.Property,
+	tplink.TotalEnergyDeviceAttribute.Property,
+	tplink.BrightnessDeviceAttribute.Property,
+	tplink.HueDeviceAttribute.Property,
+	tplink.SaturationDeviceAttribute.Property,
+	tplink.ColorTempDeviceAttribute.Property,
+}
+
+// FieldError is a problem with a single setting of the configuration.
+type FieldError struct {
+	// Field is the path of the setting, e.g. mqtt.port or devices.<id>.poll_interval.
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationError holds every problem which was found with the configuration.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	problems := make([]string, len(e))
+	for i, problem := range e {
+		problems[i] = problem.Error()
+	}
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(problems, "; "))
+}
+
+// validator collects the problems found with the configuration.
+type validator struct {
+	errors ValidationError
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) positive(field string, value int) {
+	if value <= 0 {
+		v.add(field, "must be greater than zero, got %d", value)
+	}
+}
+
+func (v *validator) notNegative(field string, value int) {
+	if value < 0 {
+		v.add(field, "must not be negative, got %d", value)
+	}
+}
+
+// file checks that the file at path exists, if it is set.
+func (v *validator) file(field, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.add(field, "unable to read file: %s", err.Error())
+	}
+}
+
+// Validate checks that the configuration is usable. If it isn't, the returned error is a ValidationError which
+// holds every problem that was found.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	c.validateMQTT(v)
+
+	if !contains(discoveryModes, c.Discovery) {
+		v.add("discovery", "must be one of %s, got '%s'", strings.Join(discoveryModes, ", "), c.Discovery)
+	}
+	// The subnet is only swept when broadcast discovery isn't used on its own.
+	if c.Discovery != "broadcast" || c.Subnet != "" {
+		c.validateSubnet(v)
+	}
+	if ip := net.ParseIP(c.Broadcast); ip == nil || ip.To4() == nil {
+		v.add("broadcast", "must be an IPv4 address, got '%s'", c.Broadcast)
+	}
+
+	v.positive("timeout", c.Timeout)
+	v.positive("interval", c.Interval)
+	v.positive("discovery_interval", c.DiscoveryInterval)
+	v.positive("workers", c.Workers)
+	v.positive("missed_polls", c.MissedPolls)
+	v.notNegative("offline_expiry", c.OfflineExpiry)
+	v.notNegative("republish_interval", c.RepublishInterval)
+
+	c.validateDeadband(v)
+	c.validateDevices(v)
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+	return nil
+}
+
+// validateSubnet checks that the subnet can be swept, which means that it must be an IPv4 subnet which isn't too
+// large to probe every address in.
+func (c *Config) validateSubnet(v *validator) {
+	_, ipnet, err := net.ParseCIDR(c.Subnet)
+	if err != nil {
+		v.add("subnet", "must be in CIDR notation, e.g. 192.168.0.0/24, got '%s'", c.Subnet)
+		return
+	}
+	ones, bits := ipnet.Mask.Size()
+	if ipnet.IP.To4() == nil || bits != net.IPv4len*8 {
+		v.add("subnet", "must be an IPv4 subnet, got '%s'", c.Subnet)
+		return
+	}
+	if ones < tplinkClient.MinSweepPrefixLength {
+		v.add("subnet", "must be a /%d or smaller to be swept, got '%s'", tplinkClient.MinSweepPrefixLength, c.Subnet)
+	}
+}
+
+func (c *Config) validateMQTT(v *validator) {
+	if c.MQTT.Host == "" {
+		v.add("mqtt.host", "must be set")
+	}
+	if c.MQTT.Port <= 0 || c.MQTT.Port > maxPort {
+		v.add("mqtt.port", "must be between 1 and %d, got %d", maxPort, c.MQTT.Port)
+	}
+	if c.MQTT.Password != "" && c.MQTT.Username == "" {
+		v.add("mqtt.password", "can't be set without mqtt.username")
+	}
+
+	if !contains(mqttSchemes, c.MQTT.Scheme) {
+		v.add("mqtt.scheme", "must be one of %s, got '%s'", strings.Join(mqttSchemes, ", "), c.MQTT.Scheme)
+	}
+	tls := c.MQTT.TLS
+	if tls != (TLSOptions{}) && !c.MQTT.IsSecure() {
+		v.add("mqtt.tls", "can only be used with the ssl or wss schemes")
+	}
+	if (tls.Cert == "") != (tls.Key == "") {
+		v.add("mqtt.tls", "cert and key must be set together")
+	}
+	v.file("mqtt.tls.ca", tls.CA)
+	v.file("mqtt.tls.cert", tls.Cert)
+	v.file("mqtt.tls.key", tls.Key)
+
+	if (c.MQTT.Path != "" || len(c.MQTT.Headers) > 0) && !c.MQTT.IsWebsocket() {
+		v.add("mqtt", "path and headers can only be used with the ws or wss schemes")
+	}
+	if c.MQTT.Path != "" && !strings.HasPrefix(c.MQTT.Path, "/") {
+		v.add("mqtt.path", "must start with /, got '%s'", c.MQTT.Path)
+	}
+	names := make([]string, 0, len(c.MQTT.Headers))
+	for name := range c.MQTT.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !headerNameRegex.MatchString(name) {
+			v.add(fmt.Sprintf("mqtt.headers.%s", name), "is not a valid header name")
+		}
+	}
+}
+
+func (c *Config) validateDeadband(v *validator) {
+	// Problems are reported in order of the key, so that they are always reported in the same order.
+	properties := make([]string, 0, len(c.Deadband))
+	for property := range c.Deadband {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+	for _, property := range properties {
+		deadband := c.Deadband[property]
+		field := fmt.Sprintf("deadband.%s", property)
+		if !contains(deadbandProperties, property) {
+			v.add(field, "unknown attribute, must be one of %s", strings.Join(deadbandProperties, ", "))
+		}
+		if deadband.Absolute < 0 {
+			v.add(field+".absolute", "must not be negative, got %g", deadband.Absolute)
+		}
+		if deadband.Percent < 0 {
+			v.add(field+".percent", "must not be negative, got %g", deadband.Percent)
+		}
+	}
+}
+
+func (c *Config) validateDevices(v *validator) {
+	keys := make([]string, 0, len(c.Devices))
+	for key := range c.Devices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	seen := make(map[string]string)
+	for _, key := range keys {
+		options := c.Devices[key]
+		field := fmt.Sprintf("devices.%s", key)
+		lower := strings.ToLower(key)
+		if !deviceIDRegex.MatchString(lower) && !macAddressRegex.MatchString(lower) {
+			// The keys are lower cased when the configuration is read, so the key may not appear as it was written.
+			v.add(field, "unknown device, must be a device id or mac address (keys are matched case-insensitively)")
+		}
+		if other, ok := seen[normalizeDeviceKey(key)]; ok {
+			v.add(field, "refers to the same device as devices.%s", other)
+		}
+		seen[normalizeDeviceKey(key)] = key
+
+		v.notNegative(field+".poll_interval", options.PollInterval)
+		if options.Ignore && (options.FriendlyName != "" || options.PollInterval != 0 || options.HomeAssistant != (HomeAssistantOptions{})) {
+			v.add(field+".ignore", "can't be combined with other options, since ignored devices aren't published")
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
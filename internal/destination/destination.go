@@ -8,5 +8,25 @@ import (
 
 // Destination is an interface which defines somewhere which events are published when a device changes state.
 type Destination interface {
-	Publish(device *tplink.Device, client mqtt.Client) error
+	// PublishConfig publishes the configuration of the device. It is only called when the device info changes.
+	PublishConfig(device *tplink.Device, client mqtt.Client) error
+	// PublishState publishes the state of the device. It is only called when the device state changes.
+	PublishState(device *tplink.Device, client mqtt.Client) error
+	PublishAvailability(device *tplink.Device, available bool, client mqtt.Client) error
+	// Remove stops publishing the device. It is called when the device is removed or expires.
+	Remove(device *tplink.Device, client mqtt.Client) error
+}
+
+// The payloads which are published to availability topics.
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// AvailabilityPayload returns the payload which is published to availability topics.
+func AvailabilityPayload(available bool) []byte {
+	if available {
+		return []byte(Online)
+	}
+	return []byte(Offline)
 }
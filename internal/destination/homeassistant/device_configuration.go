@@ -3,11 +3,56 @@ package homeassistant
 type connection []string
 
 type deviceConfiguration struct {
-	Name         string     `json:"name"`
-	CommandTopic string     `json:"command_topic"`
-	StateTopic   string     `json:"state_topic"`
-	Device       deviceInfo `json:"device"`
-	UniqueID     string     `json:"unique_id"`
+	Name             string                      `json:"name"`
+	CommandTopic     string                      `json:"command_topic"`
+	StateTopic       string                      `json:"state_topic"`
+	Availability     []availabilityConfiguration `json:"availability"`
+	AvailabilityMode string                      `json:"availability_mode"`
+	Device           deviceInfo                  `json:"device"`
+	UniqueID         string                      `json:"unique_id"`
+	Icon             string                      `json:"icon,omitempty"`
+	DeviceClass      string                      `json:"device_class,omitempty"`
+	EnabledByDefault *bool                       `json:"enabled_by_default,omitempty"`
+
+	// Only used by lights.
+	Schema              string   `json:"schema,omitempty"`
+	Brightness          bool     `json:"brightness,omitempty"`
+	BrightnessScale     int      `json:"brightness_scale,omitempty"`
+	ColorMode           bool     `json:"color_mode,omitempty"`
+	SupportedColorModes []string `json:"supported_color_modes,omitempty"`
+	MinMireds           int      `json:"min_mireds,omitempty"`
+	MaxMireds           int      `json:"max_mireds,omitempty"`
+}
+
+type sensorConfiguration struct {
+	Name              string                      `json:"name"`
+	StateTopic        string                      `json:"state_topic"`
+	Availability      []availabilityConfiguration `json:"availability"`
+	AvailabilityMode  string                      `json:"availability_mode"`
+	Device            deviceInfo                  `json:"device"`
+	UniqueID          string                      `json:"unique_id"`
+	DeviceClass       string                      `json:"device_class"`
+	UnitOfMeasurement string                      `json:"unit_of_measurement"`
+	StateClass        string                      `json:"state_class"`
+}
+
+// lightState is the json schema state payload for lights.
+type lightState struct {
+	State      string      `json:"state"`
+	Brightness int         `json:"brightness"`
+	ColorMode  string      `json:"color_mode,omitempty"`
+	Color      *lightColor `json:"color,omitempty"`
+	ColorTemp  int         `json:"color_temp,omitempty"`
+}
+
+// lightColor is the hs color of a light within the json schema.
+type lightColor struct {
+	Hue        int `json:"h"`
+	Saturation int `json:"s"`
+}
+
+type availabilityConfiguration struct {
+	Topic string `json:"topic"`
 }
 
 type deviceInfo struct {
@@ -8,14 +8,23 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/shauncampbell/tplink2mqtt/internal/config"
 	"github.com/shauncampbell/tplink2mqtt/internal/destination"
 	"github.com/shauncampbell/tplink2mqtt/pkg/tplink"
 )
 
 const (
-	homeAssistantTopicFmt = "homeassistant/switch/%s/%s"
+	homeAssistantTopicFmt = "homeassistant/%s/%s/%s"
 	on                    = "ON"
 	off                   = "OFF"
+
+	// SwitchComponent is the home assistant component used for devices which can only be turned on and off.
+	SwitchComponent = "switch"
+	// LightComponent is the home assistant component used for dimmers, bulbs and light strips.
+	LightComponent = "light"
+
+	// availabilityModeAll means an entity is only available when every availability topic says it is.
+	availabilityModeAll = "all"
 )
 
 // HomeAssistant is a destination for home assistant events.
@@ -27,51 +36,107 @@ type HomeAssistant struct {
 
 // Options is a struct for storing options for the home assistant destination.
 type Options struct {
-	// This is intentionally empty for now, but will have config options in future.
+	// BridgeStateTopic is the topic which the bridge publishes its own availability to. Entities are only available
+	// while both the bridge and the device are.
+	BridgeStateTopic string
+	// EntityOptions returns the options which customise the entity of a device. It is optional.
+	EntityOptions func(device *tplink.Device) config.HomeAssistantOptions
 }
 
-// Publish publishes the device state to Home Assistant
-func (h *HomeAssistant) Publish(device *tplink.Device, client mqtt.Client) error {
+// PublishConfig publishes the discovery configuration of the device and its sensors to Home Assistant.
+func (h *HomeAssistant) PublishConfig(device *tplink.Device, client mqtt.Client) error {
 	err := h.publishDeviceConfiguration(device, client)
 	if err != nil {
 		h.logger.Error().Msgf("failed to publish device configuration: %s", err.Error())
 		return err
 	}
 
-	err = h.publishDeviceState(device, client)
+	err = h.publishSensorConfigurations(device, client)
+	if err != nil {
+		h.logger.Error().Msgf("failed to publish sensor configuration: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// PublishState publishes the device state to Home Assistant
+func (h *HomeAssistant) PublishState(device *tplink.Device, client mqtt.Client) error {
+	err := h.publishDeviceState(device, client)
 	if err != nil {
 		h.logger.Error().Msgf("failed to publish device state: %s", err.Error())
 		return err
 	}
 
+	err = h.publishSensorStates(device, client)
+	if err != nil {
+		h.logger.Error().Msgf("failed to publish sensor state: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Remove does nothing, since the retained discovery configuration and state of the device are cleared by the
+// handler, which removes the device from Home Assistant.
+func (h *HomeAssistant) Remove(_ *tplink.Device, _ mqtt.Client) error {
 	return nil
 }
 
 func (h *HomeAssistant) publishDeviceConfiguration(device *tplink.Device, client mqtt.Client) error {
-	event := getDeviceConfiguration(device)
+	event := h.getDeviceConfiguration(device)
 	b, err := json.Marshal(event)
 	if err != nil {
 		h.logger.Error().Msgf("failed to create json: %s", err.Error())
 		return err
 	}
-	configTopic := fmt.Sprintf(homeAssistantTopicFmt, device.ID, "config")
+	configTopic := fmt.Sprintf(homeAssistantTopicFmt, Component(device), device.ID, "config")
 	h.logger.Info().Msgf("publishing device config to %s", configTopic)
 	token := client.Publish(configTopic, 1, true, b)
 	if token.Wait() && token.Error() != nil {
 		h.logger.Error().Msgf("failed to publish device to home assistant: %s", token.Error().Error())
 		return token.Error()
 	}
+
+	if Component(device) != SwitchComponent {
+		return h.clearSwitchConfiguration(device, client)
+	}
+	return nil
+}
+
+// clearSwitchConfiguration removes the switch which earlier releases created for devices which are now published
+// as lights, so that home assistant doesn't keep an orphaned switch entity for them.
+func (h *HomeAssistant) clearSwitchConfiguration(device *tplink.Device, client mqtt.Client) error {
+	switchTopic := fmt.Sprintf(homeAssistantTopicFmt, SwitchComponent, device.ID, "config")
+	token := client.Publish(switchTopic, 1, true, []byte{})
+	if token.Wait() && token.Error() != nil {
+		h.logger.Error().Msgf("failed to clear switch configuration: %s", token.Error().Error())
+		return token.Error()
+	}
 	return nil
 }
 
 func (h *HomeAssistant) publishDeviceState(device *tplink.Device, client mqtt.Client) error {
-	stateTopic := fmt.Sprintf(homeAssistantTopicFmt, device.ID, "state")
+	component := Component(device)
+	stateTopic := fmt.Sprintf(homeAssistantTopicFmt, component, device.ID, "state")
 	var state = off
 	if device.State.IsOn {
 		state = on
 	}
+
+	payload := []byte(state)
+	if component == LightComponent {
+		// Lights use the json schema so that the state, brightness and color are published together.
+		b, err := json.Marshal(getLightState(device, state))
+		if err != nil {
+			h.logger.Error().Msgf("failed to create json: %s", err.Error())
+			return err
+		}
+		payload = b
+	}
+
 	h.logger.Info().Msgf("publishing device state to %s", stateTopic)
-	token := client.Publish(stateTopic, 1, true, []byte(state))
+	token := client.Publish(stateTopic, 1, true, payload)
 	if token.Wait() && token.Error() != nil {
 		h.logger.Error().Msgf("failed to publish device to home assistant: %s", token.Error().Error())
 		return token.Error()
@@ -79,19 +144,82 @@ func (h *HomeAssistant) publishDeviceState(device *tplink.Device, client mqtt.Cl
 	return nil
 }
 
-func getDeviceConfiguration(device *tplink.Device) *deviceConfiguration {
-	return &deviceConfiguration{
+// PublishAvailability publishes whether the device is available to the topic referenced by its configuration.
+func (h *HomeAssistant) PublishAvailability(device *tplink.Device, available bool, client mqtt.Client) error {
+	availabilityTopic := getAvailabilityTopic(device)
+	h.logger.Info().Msgf("publishing device availability to %s", availabilityTopic)
+	token := client.Publish(availabilityTopic, 1, true, destination.AvailabilityPayload(available))
+	if token.Wait() && token.Error() != nil {
+		h.logger.Error().Msgf("failed to publish device availability to home assistant: %s", token.Error().Error())
+		return token.Error()
+	}
+	return nil
+}
+
+// getAvailability returns the availability topics which are referenced by every entity belonging to the device.
+func (h *HomeAssistant) getAvailability(device *tplink.Device) []availabilityConfiguration {
+	availability := make([]availabilityConfiguration, 0)
+	if h.options.BridgeStateTopic != "" {
+		availability = append(availability, availabilityConfiguration{Topic: h.options.BridgeStateTopic})
+	}
+	return append(availability, availabilityConfiguration{Topic: getAvailabilityTopic(device)})
+}
+
+// getAvailabilityTopic returns the topic which the availability of the device is published to. It is shared by
+// every entity belonging to the device.
+func getAvailabilityTopic(device *tplink.Device) string {
+	return fmt.Sprintf(homeAssistantTopicFmt, Component(device), device.ID, "availability")
+}
+
+// Component returns the home assistant component which the device is published as.
+func Component(device *tplink.Device) string {
+	if device.Info.IsLight() {
+		return LightComponent
+	}
+	return SwitchComponent
+}
+
+func (h *HomeAssistant) getDeviceConfiguration(device *tplink.Device) *deviceConfiguration {
+	component := Component(device)
+	config := &deviceConfiguration{
+		Name:             device.Info.FriendlyName,
+		CommandTopic:     fmt.Sprintf(homeAssistantTopicFmt, component, device.ID, "set"),
+		StateTopic:       fmt.Sprintf(homeAssistantTopicFmt, component, device.ID, "state"),
+		Availability:     h.getAvailability(device),
+		AvailabilityMode: availabilityModeAll,
+		Device:           getDeviceInfo(device),
+		UniqueID:         device.ID,
+	}
+
+	if component == LightComponent {
+		setLightConfiguration(config, device)
+	}
+	h.applyEntityOptions(config, device)
+	return config
+}
+
+// applyEntityOptions applies the options which have been configured for the entity of the device.
+func (h *HomeAssistant) applyEntityOptions(config *deviceConfiguration, device *tplink.Device) {
+	if h.options.EntityOptions == nil {
+		return
+	}
+	options := h.options.EntityOptions(device)
+	if options.Name != "" {
+		config.Name = options.Name
+	}
+	config.Icon = options.Icon
+	config.DeviceClass = options.DeviceClass
+	config.EnabledByDefault = options.EnabledByDefault
+}
+
+// getDeviceInfo returns the device block which is shared by every entity belonging to the device.
+func getDeviceInfo(device *tplink.Device) deviceInfo {
+	return deviceInfo{
+		Manufacturer: device.Info.Vendor,
+		Connections:  []connection{{"ip", device.Info.NetworkAddress}},
+		Identifiers:  []string{device.ID},
+		Model:        device.Info.Model,
 		Name:         device.Info.FriendlyName,
-		CommandTopic: fmt.Sprintf(homeAssistantTopicFmt, device.ID, "set"),
-		StateTopic:   fmt.Sprintf(homeAssistantTopicFmt, device.ID, "state"),
-		Device: deviceInfo{
-			Manufacturer: device.Info.Vendor,
-			Connections:  []connection{{"ip", device.Info.NetworkAddress}},
-			Identifiers:  []string{device.ID},
-			Model:        device.Info.Model,
-			Name:         device.Info.FriendlyName,
-		},
-		UniqueID: device.ID,
 	}
 }
 
@@ -0,0 +1,60 @@
+package homeassistant
+
+import "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+
+const (
+	jsonSchema      = "json"
+	brightnessScale = 100
+
+	brightnessColorMode = "brightness"
+	hsColorMode         = "hs"
+	colorTempColorMode  = "color_temp"
+
+	// miredsPerKelvin converts between kelvin, which devices use, and mireds, which home assistant uses.
+	miredsPerKelvin = 1000000
+)
+
+// setLightConfiguration adds the json schema light options to the configuration based on what the device exposes.
+func setLightConfiguration(config *deviceConfiguration, device *tplink.Device) {
+	config.Schema = jsonSchema
+	config.Brightness = device.Info.HasAttribute(tplink.BrightnessDeviceAttribute.Property)
+	config.BrightnessScale = brightnessScale
+	config.ColorMode = true
+
+	if device.Info.HasAttribute(tplink.HueDeviceAttribute.Property) {
+		config.SupportedColorModes = append(config.SupportedColorModes, hsColorMode)
+	}
+	if attr := device.Info.Attribute(tplink.ColorTempDeviceAttribute.Property); attr != nil {
+		config.SupportedColorModes = append(config.SupportedColorModes, colorTempColorMode)
+		config.MinMireds = KelvinToMireds(attr.ValueMax)
+		config.MaxMireds = KelvinToMireds(attr.ValueMin)
+	}
+	if len(config.SupportedColorModes) == 0 {
+		config.SupportedColorModes = []string{brightnessColorMode}
+	}
+}
+
+// getLightState returns the json schema state payload for the device.
+func getLightState(device *tplink.Device, state string) *lightState {
+	ls := &lightState{State: state, Brightness: device.State.Brightness}
+	switch {
+	case device.Info.HasAttribute(tplink.ColorTempDeviceAttribute.Property) && device.State.ColorTemp > 0:
+		ls.ColorMode = colorTempColorMode
+		ls.ColorTemp = KelvinToMireds(device.State.ColorTemp)
+	case device.Info.HasAttribute(tplink.HueDeviceAttribute.Property):
+		ls.ColorMode = hsColorMode
+		ls.Color = &lightColor{Hue: device.State.Hue, Saturation: device.State.Saturation}
+	default:
+		ls.ColorMode = brightnessColorMode
+	}
+	return ls
+}
+
+// KelvinToMireds converts a color temperature in kelvin to mireds. Conversion in either direction is the same
+// calculation, so it can also be used to convert mireds to kelvin.
+func KelvinToMireds(value int) int {
+	if value <= 0 {
+		return 0
+	}
+	return miredsPerKelvin / value
+}
@@ -0,0 +1,112 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+const (
+	// sensorTopicFmt includes the device id as the node id so that every reading of a device is grouped together.
+	sensorTopicFmt = "homeassistant/sensor/%s/%s/%s"
+
+	measurementStateClass = "measurement"
+	// totalIncreasingStateClass tells home assistant that a decrease in the value is a counter reset rather than
+	// negative usage, which is what the energy dashboard requires. The counter of the device is published as it is,
+	// so resets of the device are handled by home assistant.
+	totalIncreasingStateClass = "total_increasing"
+)
+
+// sensorClass describes how home assistant should interpret a numeric attribute.
+type sensorClass struct {
+	deviceClass string
+	stateClass  string
+}
+
+// sensorClasses maps the properties of the numeric attributes which are published as sensors onto their class.
+// Attributes which can be controlled, such as brightness, are part of the light entity instead.
+var sensorClasses = map[string]sensorClass{
+	tplink.VoltageDeviceAttribute.Property:     {deviceClass: "voltage", stateClass: measurementStateClass},
+	tplink.CurrentDeviceAttribute.Property:     {deviceClass: "current", stateClass: measurementStateClass},
+	tplink.PowerDeviceAttribute.Property:       {deviceClass: "power", stateClass: measurementStateClass},
+	tplink.TotalEnergyDeviceAttribute.Property: {deviceClass: "energy", stateClass: totalIncreasingStateClass},
+}
+
+// sensor is a numeric attribute of a device which is published as a sensor.
+type sensor struct {
+	attr  *tplink.DeviceAttribute
+	class sensorClass
+	value float64
+}
+
+// getSensors returns the numeric attributes of the device which are published as sensors.
+func getSensors(device *tplink.Device) []sensor {
+	sensors := make([]sensor, 0)
+	for i := range device.Info.Exposes {
+		attr := &device.Info.Exposes[i]
+		class, ok := sensorClasses[attr.Property]
+		if !ok || attr.Type != "numeric" {
+			continue
+		}
+
+		value, ok := device.State.NumericValue(attr.Property)
+		if !ok {
+			continue
+		}
+		sensors = append(sensors, sensor{attr: attr, class: class, value: value})
+	}
+	return sensors
+}
+
+// publishSensorConfigurations publishes a sensor configuration for each numeric attribute the device exposes.
+func (h *HomeAssistant) publishSensorConfigurations(device *tplink.Device, client mqtt.Client) error {
+	for _, s := range getSensors(device) {
+		b, err := json.Marshal(h.getSensorConfiguration(device, s.attr, s.class))
+		if err != nil {
+			h.logger.Error().Msgf("failed to create json: %s", err.Error())
+			return err
+		}
+
+		configTopic := fmt.Sprintf(sensorTopicFmt, device.ID, s.attr.Property, "config")
+		h.logger.Debug().Msgf("publishing sensor config to %s", configTopic)
+		token := client.Publish(configTopic, 1, true, b)
+		if token.Wait() && token.Error() != nil {
+			h.logger.Error().Msgf("failed to publish sensor to home assistant: %s", token.Error().Error())
+			return token.Error()
+		}
+	}
+	return nil
+}
+
+// publishSensorStates publishes the state of each numeric attribute the device exposes.
+func (h *HomeAssistant) publishSensorStates(device *tplink.Device, client mqtt.Client) error {
+	for _, s := range getSensors(device) {
+		stateTopic := fmt.Sprintf(sensorTopicFmt, device.ID, s.attr.Property, "state")
+		h.logger.Debug().Msgf("publishing sensor state to %s", stateTopic)
+		token := client.Publish(stateTopic, 1, true, []byte(strconv.FormatFloat(s.value, 'f', -1, 32)))
+		if token.Wait() && token.Error() != nil {
+			h.logger.Error().Msgf("failed to publish sensor to home assistant: %s", token.Error().Error())
+			return token.Error()
+		}
+	}
+	return nil
+}
+
+func (h *HomeAssistant) getSensorConfiguration(
+	device *tplink.Device, attr *tplink.DeviceAttribute, class sensorClass,
+) *sensorConfiguration {
+	return &sensorConfiguration{
+		Name:              fmt.Sprintf("%s %s", device.Info.FriendlyName, attr.Name),
+		StateTopic:        fmt.Sprintf(sensorTopicFmt, device.ID, attr.Property, "state"),
+		Availability:      h.getAvailability(device),
+		AvailabilityMode:  availabilityModeAll,
+		Device:            getDeviceInfo(device),
+		UniqueID:          fmt.Sprintf("%s_%s", device.ID, attr.Property),
+		DeviceClass:       class.deviceClass,
+		UnitOfMeasurement: attr.Unit,
+		StateClass:        class.stateClass,
+	}
+}
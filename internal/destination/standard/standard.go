@@ -4,7 +4,7 @@ package standard
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sync"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog"
@@ -20,6 +20,7 @@ type Standard struct {
 	options Options
 	devices map[string]*tplink.Device
 	logger  zerolog.Logger
+	mu      sync.Mutex
 	destination.Destination
 }
 
@@ -33,30 +34,31 @@ func New(options Options) destination.Destination {
 	return &Standard{options: options, logger: log.Logger, devices: make(map[string]*tplink.Device)}
 }
 
-// Publish publishes the device state to the standard mqtt destinations
-func (s *Standard) Publish(device *tplink.Device, client mqtt.Client) error {
-	err := s.publishDeviceConfiguration(device, client)
-	if err != nil {
-		s.logger.Error().Msgf("failed to publish device configuration: %s", err.Error())
-		return err
-	}
-
-	err = s.publishDeviceState(device, client)
-	if err != nil {
-		s.logger.Error().Msgf("failed to publish device state: %s", err.Error())
-		return err
-	}
+// PublishConfig publishes the list of known devices to the standard mqtt destination.
+func (s *Standard) PublishConfig(device *tplink.Device, client mqtt.Client) error {
+	s.mu.Lock()
+	s.devices[device.ID] = device
+	event := s.getDeviceConfiguration()
+	s.mu.Unlock()
 
-	return nil
+	return s.publishDeviceConfiguration(event, client)
 }
 
-func (s *Standard) publishDeviceConfiguration(device *tplink.Device, client mqtt.Client) error {
-	if s.devices[device.ID] != nil {
-		// If the device has already been published no need to publish it again.
+// Remove removes the device from the list of known devices and publishes the list again.
+func (s *Standard) Remove(device *tplink.Device, client mqtt.Client) error {
+	s.mu.Lock()
+	if _, ok := s.devices[device.ID]; !ok {
+		s.mu.Unlock()
 		return nil
 	}
-
+	delete(s.devices, device.ID)
 	event := s.getDeviceConfiguration()
+	s.mu.Unlock()
+
+	return s.publishDeviceConfiguration(event, client)
+}
+
+func (s *Standard) publishDeviceConfiguration(event []*tplink.Device, client mqtt.Client) error {
 	b, err := json.Marshal(event)
 	if err != nil {
 		s.logger.Error().Msgf("failed to create json: %s", err.Error())
@@ -66,11 +68,19 @@ func (s *Standard) publishDeviceConfiguration(device *tplink.Device, client mqtt
 	s.logger.Info().Msgf("publishing device config to %s", configTopic)
 	token := client.Publish(configTopic, 1, true, b)
 	if token.Wait() && token.Error() != nil {
-		s.logger.Error().Msgf("failed to publish device to home assistant: %s", token.Error().Error())
+		s.logger.Error().Msgf("failed to publish device configuration: %s", token.Error().Error())
 		return token.Error()
 	}
+	return nil
+}
 
-	s.devices[device.ID] = device
+// PublishState publishes the device state to the standard mqtt destination.
+func (s *Standard) PublishState(device *tplink.Device, client mqtt.Client) error {
+	err := s.publishDeviceState(device, client)
+	if err != nil {
+		s.logger.Error().Msgf("failed to publish device state: %s", err.Error())
+		return err
+	}
 	return nil
 }
 
@@ -87,6 +97,16 @@ func (s *Standard) publishDeviceState(device *tplink.Device, client mqtt.Client)
 			event[field.Property] = device.State.Current
 		case "power":
 			event[field.Property] = device.State.Power
+		case "total_energy":
+			event[field.Property] = device.State.TotalEnergy
+		case "brightness":
+			event[field.Property] = device.State.Brightness
+		case "hue":
+			event[field.Property] = device.State.Hue
+		case "saturation":
+			event[field.Property] = device.State.Saturation
+		case "color_temp":
+			event[field.Property] = device.State.ColorTemp
 		}
 	}
 
@@ -96,12 +116,12 @@ func (s *Standard) publishDeviceState(device *tplink.Device, client mqtt.Client)
 		return err
 	}
 
-	s.logger.Info().Msgf("publishing device state to tplink2mqtt/%s", sanitizeFriendlyName(device.Info.FriendlyName))
+	s.logger.Info().Msgf("publishing device state to tplink2mqtt/%s", tplink.SanitizeFriendlyName(device.Info.FriendlyName))
 	token := client.Publish(
-		fmt.Sprintf("tplink2mqtt/%s", sanitizeFriendlyName(device.Info.FriendlyName)), 1, false, b)
+		fmt.Sprintf("tplink2mqtt/%s", tplink.SanitizeFriendlyName(device.Info.FriendlyName)), 1, false, b)
 	if token.Wait() && token.Error() != nil {
-		s.logger.Error().Msgf("failed to publish device list: %s", token.Error().Error())
-		return err
+		s.logger.Error().Msgf("failed to publish device state: %s", token.Error().Error())
+		return token.Error()
 	}
 	return nil
 }
@@ -114,8 +134,14 @@ func (s *Standard) getDeviceConfiguration() []*tplink.Device {
 	return out
 }
 
-func sanitizeFriendlyName(friendlyName string) string {
-	str := strings.ToLower(friendlyName)
-	str = strings.ReplaceAll(str, " ", "_")
-	return str
+// PublishAvailability publishes whether the device is available to tplink2mqtt/<friendly_name>/availability.
+func (s *Standard) PublishAvailability(device *tplink.Device, available bool, client mqtt.Client) error {
+	availabilityTopic := fmt.Sprintf("tplink2mqtt/%s/availability", tplink.SanitizeFriendlyName(device.Info.FriendlyName))
+	s.logger.Info().Msgf("publishing device availability to %s", availabilityTopic)
+	token := client.Publish(availabilityTopic, 1, true, destination.AvailabilityPayload(available))
+	if token.Wait() && token.Error() != nil {
+		s.logger.Error().Msgf("failed to publish device availability: %s", token.Error().Error())
+		return token.Error()
+	}
+	return nil
 }
@@ -0,0 +1,192 @@
+// Package energy provides a listener which answers requests for the energy history of a device.
+package energy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/shauncampbell/tplink2mqtt/internal/listener"
+	"github.com/shauncampbell/tplink2mqtt/internal/tplink"
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+const (
+	energyTopicFmt          = "tplink2mqtt/%s/energy/%s"
+	energyTopicRegexMatches = 2
+
+	// DailyPeriod requests the energy used on each day of a month.
+	DailyPeriod = "daily"
+	// MonthlyPeriod requests the energy used in each month of a year.
+	MonthlyPeriod = "monthly"
+
+	statusOK    = "ok"
+	statusError = "error"
+)
+
+var energyTopicRegex = regexp.MustCompile(`^tplink2mqtt/(.+?)/energy/request$`)
+
+// Energy is a listener for energy history requests.
+type Energy struct {
+	options Options
+	devices map[string]*tplinkModel.Device
+	logger  zerolog.Logger
+	mu      sync.Mutex
+	listener.Listener
+}
+
+// Options is a struct for storing options for the energy listener.
+type Options struct {
+	Timeout int
+	Subnet  string
+}
+
+// request is the payload of a request for energy history. Year and month default to the current year and month.
+type request struct {
+	Period      string      `json:"period"`
+	Year        int         `json:"year"`
+	Month       int         `json:"month"`
+	Transaction interface{} `json:"transaction,omitempty"`
+}
+
+// response is the payload published in response to a request for energy history.
+type response struct {
+	Period      string                   `json:"period"`
+	Year        int                      `json:"year"`
+	Month       int                      `json:"month,omitempty"`
+	Data        []tplinkModel.EnergyStat `json:"data"`
+	Status      string                   `json:"status"`
+	Error       string                   `json:"error,omitempty"`
+	Transaction interface{}              `json:"transaction,omitempty"`
+}
+
+// Listen subscribes to energy history requests for devices which have an energy meter.
+func (e *Energy) Listen(device *tplinkModel.Device, client mqtt.Client, callback listener.StateChangedCallback) error {
+	if !device.Info.HasAttribute(tplinkModel.TotalEnergyDeviceAttribute.Property) {
+		return nil
+	}
+
+	name := tplinkModel.SanitizeFriendlyName(device.Info.FriendlyName)
+	e.mu.Lock()
+	_, subscribed := e.devices[name]
+	e.devices[name] = device
+	e.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+
+	requestTopic := fmt.Sprintf(energyTopicFmt, name, "request")
+	token := client.Subscribe(requestTopic, 1, e.handleRequest)
+	if token.Wait() && token.Error() != nil {
+		e.logger.Error().Msgf("failed to subscribe to energy requests: %s", token.Error().Error())
+		e.mu.Lock()
+		delete(e.devices, name)
+		e.mu.Unlock()
+		return token.Error()
+	}
+	e.logger.Info().Msgf("subscribed to %s", requestTopic)
+	return nil
+}
+
+// Reset unsubscribes from the energy request topic of every device.
+func (e *Energy) Reset(client mqtt.Client) error {
+	e.mu.Lock()
+	topics := make([]string, 0, len(e.devices))
+	for name := range e.devices {
+		topics = append(topics, fmt.Sprintf(energyTopicFmt, name, "request"))
+	}
+	e.devices = make(map[string]*tplinkModel.Device)
+	e.mu.Unlock()
+
+	if len(topics) == 0 {
+		return nil
+	}
+	token := client.Unsubscribe(topics...)
+	if token.Wait() && token.Error() != nil {
+		e.logger.Error().Msgf("failed to unsubscribe from energy requests: %s", token.Error().Error())
+		return token.Error()
+	}
+	return nil
+}
+
+func (e *Energy) handleRequest(client mqtt.Client, message mqtt.Message) {
+	logger := e.logger.With().Str("topic", message.Topic()).Logger()
+	matches := energyTopicRegex.FindStringSubmatch(message.Topic())
+	if len(matches) < energyTopicRegexMatches {
+		logger.Error().Msgf("unable to determine device from topic")
+		return
+	}
+
+	e.mu.Lock()
+	device := e.devices[matches[1]]
+	e.mu.Unlock()
+	if device == nil {
+		logger.Error().Msgf("unknown device: %s", matches[1])
+		return
+	}
+
+	var req request
+	if err := json.Unmarshal(message.Payload(), &req); err != nil {
+		logger.Error().Msgf("unable to parse energy request: %s", err.Error())
+		return
+	}
+
+	resp := e.collect(device, &req, &logger)
+	b, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error().Msgf("failed to create json: %s", err.Error())
+		return
+	}
+
+	responseTopic := fmt.Sprintf(energyTopicFmt, matches[1], "response")
+	token := client.Publish(responseTopic, 1, false, b)
+	if token.Wait() && token.Error() != nil {
+		logger.Error().Msgf("failed to publish energy response: %s", token.Error().Error())
+	}
+}
+
+// collect retrieves the requested history from the device.
+func (e *Energy) collect(device *tplinkModel.Device, req *request, logger *zerolog.Logger) *response {
+	now := time.Now()
+	if req.Period == "" {
+		req.Period = DailyPeriod
+	}
+	if req.Year == 0 {
+		req.Year = now.Year()
+	}
+	if req.Month == 0 && req.Period == DailyPeriod {
+		req.Month = int(now.Month())
+	}
+
+	resp := &response{Period: req.Period, Year: req.Year, Month: req.Month, Transaction: req.Transaction, Status: statusOK}
+	t := tplink.New(tplink.Options{Subnet: e.options.Subnet, Timeout: time.Duration(e.options.Timeout) * time.Second}, logger)
+
+	var err error
+	switch req.Period {
+	case DailyPeriod:
+		resp.Data, err = t.GetDayStats(device.Info.NetworkAddress, device.ChildID, req.Year, req.Month)
+	case MonthlyPeriod:
+		resp.Month = 0
+		resp.Data, err = t.GetMonthStats(device.Info.NetworkAddress, device.ChildID, req.Year)
+	default:
+		err = fmt.Errorf("unknown period: %s", req.Period)
+	}
+
+	if err != nil {
+		logger.Error().Msgf("failed to collect energy history: %s", err.Error())
+		resp.Status = statusError
+		resp.Error = err.Error()
+		resp.Data = []tplinkModel.EnergyStat{}
+	}
+	return resp
+}
+
+// New creates a new energy listener.
+func New(options Options) listener.Listener {
+	return &Energy{options: options, logger: log.Logger, devices: make(map[string]*tplinkModel.Device)}
+}
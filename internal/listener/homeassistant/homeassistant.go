@@ -2,35 +2,37 @@
 package homeassistant
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog"
-	"github.com/shauncampbell/golang-tplink-hs100/pkg/configuration"
-	"github.com/shauncampbell/golang-tplink-hs100/pkg/hs100"
+	haDestination "github.com/shauncampbell/tplink2mqtt/internal/destination/homeassistant"
 	"github.com/shauncampbell/tplink2mqtt/internal/listener"
 	"github.com/shauncampbell/tplink2mqtt/internal/tplink"
 	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
 )
 
 const (
-	homeAssistantTopicFmt          = "homeassistant/switch/%s/%s"
+	homeAssistantTopicFmt          = "homeassistant/%s/%s/%s"
 	homeAssistantTopicRegexMatches = 2
 	on                             = "ON"
 	off                            = "OFF"
 )
 
-var homeAssistantTopicRegex = regexp.MustCompile(`^homeassistant/switch/(.+?)/set$`)
+var homeAssistantTopicRegex = regexp.MustCompile(`^homeassistant/(?:switch|light)/(.+?)/set$`)
 
 // HomeAssistant is a listener for home assistant events.
 type HomeAssistant struct {
 	options Options
 	devices map[string]*tplinkModel.Device
 	logger  zerolog.Logger
+	mu      sync.Mutex
 	listener.Listener
 }
 
@@ -42,7 +44,12 @@ type Options struct {
 
 // Listen listens for events on home assistant mqtt channels.
 func (h *HomeAssistant) Listen(device *tplinkModel.Device, client mqtt.Client, callback listener.StateChangedCallback) error {
-	if h.devices[device.ID] != nil {
+	// The latest device is always kept so that commands are sent to its current address if it has moved.
+	h.mu.Lock()
+	_, subscribed := h.devices[device.ID]
+	h.devices[device.ID] = device
+	h.mu.Unlock()
+	if subscribed {
 		return nil
 	}
 
@@ -50,18 +57,47 @@ func (h *HomeAssistant) Listen(device *tplinkModel.Device, client mqtt.Client, c
 		token := client.Connect()
 		if token.Wait() && token.Error() != nil {
 			h.logger.Error().Msgf("failed to connect to mqtt: %s", token.Error().Error())
+			h.forget(device.ID)
 			return token.Error()
 		}
 	}
 
-	setTopic := fmt.Sprintf(homeAssistantTopicFmt, device.ID, "set")
+	setTopic := fmt.Sprintf(homeAssistantTopicFmt, haDestination.Component(device), device.ID, "set")
 	token := client.Subscribe(setTopic, 1, h.handleHomeAssistantUpdate(callback))
 	if token.Wait() && token.Error() != nil {
 		h.logger.Error().Msgf("failed to subscribe to home assistant device state: %s", token.Error().Error())
+		h.forget(device.ID)
 		return token.Error()
 	}
 	h.logger.Info().Msgf("subscribed to %s", setTopic)
-	h.devices[device.ID] = device
+	return nil
+}
+
+// forget removes the device so that the next call to Listen subscribes again.
+func (h *HomeAssistant) forget(id string) {
+	h.mu.Lock()
+	delete(h.devices, id)
+	h.mu.Unlock()
+}
+
+// Reset unsubscribes from the set topic of every device.
+func (h *HomeAssistant) Reset(client mqtt.Client) error {
+	h.mu.Lock()
+	topics := make([]string, 0, len(h.devices))
+	for _, device := range h.devices {
+		topics = append(topics, fmt.Sprintf(homeAssistantTopicFmt, haDestination.Component(device), device.ID, "set"))
+	}
+	h.devices = make(map[string]*tplinkModel.Device)
+	h.mu.Unlock()
+
+	if len(topics) == 0 {
+		return nil
+	}
+	token := client.Unsubscribe(topics...)
+	if token.Wait() && token.Error() != nil {
+		h.logger.Error().Msgf("failed to unsubscribe from home assistant topics: %s", token.Error().Error())
+		return token.Error()
+	}
 	return nil
 }
 
@@ -82,31 +118,28 @@ func (h *HomeAssistant) handleHomeAssistantUpdate(callback listener.StateChanged
 		payload := message.Payload()
 		logger.Info().Msgf("received request to set state of device to %s", string(payload))
 
+		h.mu.Lock()
 		device := h.devices[deviceID[1]]
+		h.mu.Unlock()
 		if device == nil {
 			logger.Error().Msgf("unknown device: %s", deviceID[1])
 			return
 		}
 
 		ipAddress := device.Info.NetworkAddress
+		t := tplink.New(tplink.Options{Subnet: h.options.Subnet, Timeout: time.Duration(h.options.Timeout) * time.Second}, &logger)
+
+		change, err := parseCommand(device, payload)
+		if err != nil {
+			logger.Error().Msgf("failed to parse command: %s", err.Error())
+			return
+		}
 
-		conn := hs100.NewHs100(ipAddress, configuration.Default().WithTimeout(time.Duration(h.options.Timeout)*time.Second))
-
-		if string(payload) == on {
-			err := conn.TurnOn()
-			if err != nil {
-				logger.Error().Msgf("failed to turn on device: %s", err.Error())
-				return
-			}
-		} else if string(payload) == off {
-			err := conn.TurnOff()
-			if err != nil {
-				logger.Error().Msgf("failed to turn off device: %s", err.Error())
-				return
-			}
+		if err = t.SetState(device, change); err != nil {
+			logger.Error().Msgf("failed to set device state: %s", err.Error())
+			return
 		}
 
-		t := tplink.New(h.options.Subnet, time.Duration(h.options.Timeout)*time.Second, &logger)
 		dstate, err := t.CollectDeviceState(ipAddress)
 		if err != nil {
 			logger.Error().Msgf("failed to collect device state: %s", err.Error())
@@ -117,6 +150,75 @@ func (h *HomeAssistant) handleHomeAssistantUpdate(callback listener.StateChanged
 	}
 }
 
+// lightCommand is the json schema command payload which home assistant sends to lights.
+type lightCommand struct {
+	State      string `json:"state"`
+	Brightness *int   `json:"brightness"`
+	Color      *struct {
+		Hue        float64 `json:"h"`
+		Saturation float64 `json:"s"`
+	} `json:"color"`
+	// ColorTemp is in mireds.
+	ColorTemp *int `json:"color_temp"`
+	// Transition is in seconds.
+	Transition *float64 `json:"transition"`
+}
+
+const millisecondsPerSecond = 1000
+
+// stateChange converts the command into a change to the state of the light.
+func (c *lightCommand) stateChange() (tplink.StateChange, error) {
+	var state tplink.StateChange
+	if c.State != "" {
+		isOn, err := parseState(c.State)
+		if err != nil {
+			return state, err
+		}
+		state.On = &isOn
+	}
+
+	state.Brightness = c.Brightness
+	if c.Color != nil {
+		hue, saturation := int(c.Color.Hue), int(c.Color.Saturation)
+		state.Hue, state.Saturation = &hue, &saturation
+	}
+	if c.ColorTemp != nil {
+		kelvin := haDestination.KelvinToMireds(*c.ColorTemp)
+		state.ColorTemp = &kelvin
+	}
+	if c.Transition != nil {
+		transition := int(*c.Transition * millisecondsPerSecond)
+		state.Transition = &transition
+	}
+	return state, nil
+}
+
+// parseCommand converts the payload sent by home assistant into a change to the state of the device. Switches are
+// sent a plain ON or OFF while lights use the json schema.
+func parseCommand(device *tplinkModel.Device, payload []byte) (tplink.StateChange, error) {
+	if haDestination.Component(device) != haDestination.LightComponent {
+		isOn, err := parseState(string(payload))
+		return tplink.StateChange{On: &isOn}, err
+	}
+
+	var command lightCommand
+	if err := json.Unmarshal(payload, &command); err != nil {
+		return tplink.StateChange{}, fmt.Errorf("unable to parse light command: %w", err)
+	}
+	return command.stateChange()
+}
+
+func parseState(state string) (bool, error) {
+	switch state {
+	case on:
+		return true, nil
+	case off:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown state: %s", state)
+	}
+}
+
 // New creates a new Home Assistant destination.
 func New(options Options) listener.Listener {
 	return &HomeAssistant{options: options, logger: log.Logger, devices: make(map[string]*tplinkModel.Device)}
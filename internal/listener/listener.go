@@ -9,6 +9,9 @@ import (
 // Listener is an interface which defines a location which will listen for events for a specific device.
 type Listener interface {
 	Listen(device *tplink.Device, client mqtt.Client, callback StateChangedCallback) error
+	// Reset unsubscribes from the topics of every device, so that they are subscribed to again by the next call to
+	// Listen. It is used when the configuration changes, e.g. when devices are renamed.
+	Reset(client mqtt.Client) error
 }
 
 // StateChangedCallback is an interface which defines a callback where a listener can tell the rest of
@@ -0,0 +1,222 @@
+// Package standard provides a listener for commands sent to the standard tplink2mqtt topics.
+package standard
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/shauncampbell/tplink2mqtt/internal/listener"
+	"github.com/shauncampbell/tplink2mqtt/internal/tplink"
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+const (
+	standardTopicFmt          = "tplink2mqtt/%s/%s"
+	standardTopicRegexMatches = 2
+	on                        = "ON"
+	off                       = "OFF"
+	toggle                    = "TOGGLE"
+)
+
+var (
+	setTopicRegex = regexp.MustCompile(`^tplink2mqtt/(.+?)/set$`)
+	getTopicRegex = regexp.MustCompile(`^tplink2mqtt/(.+?)/get$`)
+)
+
+// Standard is a listener for commands sent to tplink2mqtt/<friendly_name>/set and requests for the latest state
+// sent to tplink2mqtt/<friendly_name>/get.
+type Standard struct {
+	options Options
+	devices map[string]*tplinkModel.Device
+	logger  zerolog.Logger
+	mu      sync.Mutex
+	listener.Listener
+}
+
+// Options is a struct for storing options for the standard listener.
+type Options struct {
+	Timeout int
+	Subnet  string
+}
+
+// command is the json payload which can be sent to the set topic. The properties match those published in the
+// device state, so any subset of them can be sent.
+type command struct {
+	// State is ON, OFF or TOGGLE.
+	State      string `json:"state"`
+	Brightness *int   `json:"brightness"`
+	Hue        *int   `json:"hue"`
+	Saturation *int   `json:"saturation"`
+	// ColorTemp is in kelvin.
+	ColorTemp *int `json:"color_temp"`
+	// Transition is in milliseconds.
+	Transition *int `json:"transition"`
+}
+
+// Listen subscribes to the set and get topics of the device.
+func (s *Standard) Listen(device *tplinkModel.Device, client mqtt.Client, callback listener.StateChangedCallback) error {
+	name := tplinkModel.SanitizeFriendlyName(device.Info.FriendlyName)
+
+	// The latest state is always kept so that TOGGLE knows which way to switch the device.
+	s.mu.Lock()
+	_, subscribed := s.devices[name]
+	s.devices[name] = device
+	s.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+
+	topics := map[string]mqtt.MessageHandler{
+		fmt.Sprintf(standardTopicFmt, name, "set"): s.handleSet(callback),
+		fmt.Sprintf(standardTopicFmt, name, "get"): s.handleGet(callback),
+	}
+	for topic, handler := range topics {
+		token := client.Subscribe(topic, 1, handler)
+		if token.Wait() && token.Error() != nil {
+			s.logger.Error().Msgf("failed to subscribe to %s: %s", topic, token.Error().Error())
+			s.mu.Lock()
+			delete(s.devices, name)
+			s.mu.Unlock()
+			return token.Error()
+		}
+		s.logger.Info().Msgf("subscribed to %s", topic)
+	}
+	return nil
+}
+
+// Reset unsubscribes from the set and get topics of every device.
+func (s *Standard) Reset(client mqtt.Client) error {
+	s.mu.Lock()
+	topics := make([]string, 0, len(s.devices)*2)
+	for name := range s.devices {
+		topics = append(topics, fmt.Sprintf(standardTopicFmt, name, "set"), fmt.Sprintf(standardTopicFmt, name, "get"))
+	}
+	s.devices = make(map[string]*tplinkModel.Device)
+	s.mu.Unlock()
+
+	if len(topics) == 0 {
+		return nil
+	}
+	token := client.Unsubscribe(topics...)
+	if token.Wait() && token.Error() != nil {
+		s.logger.Error().Msgf("failed to unsubscribe from standard topics: %s", token.Error().Error())
+		return token.Error()
+	}
+	return nil
+}
+
+// device returns the device which the topic belongs to.
+func (s *Standard) device(topic string, regex *regexp.Regexp) (*tplinkModel.Device, error) {
+	matches := regex.FindStringSubmatch(topic)
+	if len(matches) < standardTopicRegexMatches {
+		return nil, fmt.Errorf("unable to determine device from topic")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device := s.devices[matches[1]]
+	if device == nil {
+		return nil, fmt.Errorf("unknown device: %s", matches[1])
+	}
+	return device, nil
+}
+
+func (s *Standard) handleGet(callback listener.StateChangedCallback) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		logger := s.logger.With().Str("topic", message.Topic()).Logger()
+		device, err := s.device(message.Topic(), getTopicRegex)
+		if err != nil {
+			logger.Error().Msgf("unable to handle request: %s", err.Error())
+			return
+		}
+		logger = logger.With().Str("device_id", device.ID).Logger()
+		logger.Info().Msgf("received request to refresh state of device")
+
+		t := tplink.New(tplink.Options{Subnet: s.options.Subnet, Timeout: time.Duration(s.options.Timeout) * time.Second}, &logger)
+		dstate, err := t.CollectDeviceState(device.Info.NetworkAddress)
+		if err != nil {
+			logger.Error().Msgf("failed to collect device state: %s", err.Error())
+			return
+		}
+
+		callback(dstate, client)
+	}
+}
+
+func (s *Standard) handleSet(callback listener.StateChangedCallback) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		logger := s.logger.With().Str("topic", message.Topic()).Logger()
+		device, err := s.device(message.Topic(), setTopicRegex)
+		if err != nil {
+			logger.Error().Msgf("unable to handle request: %s", err.Error())
+			return
+		}
+		logger = logger.With().Str("device_id", device.ID).Logger()
+		logger.Info().Msgf("received request to set state of device to %s", string(message.Payload()))
+
+		var cmd command
+		if err = json.Unmarshal(message.Payload(), &cmd); err != nil {
+			logger.Error().Msgf("unable to parse command: %s", err.Error())
+			return
+		}
+
+		change, err := cmd.stateChange(device)
+		if err != nil {
+			logger.Error().Msgf("failed to parse command: %s", err.Error())
+			return
+		}
+
+		t := tplink.New(tplink.Options{Subnet: s.options.Subnet, Timeout: time.Duration(s.options.Timeout) * time.Second}, &logger)
+		if err = t.SetState(device, change); err != nil {
+			logger.Error().Msgf("failed to set device state: %s", err.Error())
+			return
+		}
+
+		dstate, err := t.CollectDeviceState(device.Info.NetworkAddress)
+		if err != nil {
+			logger.Error().Msgf("failed to collect device state: %s", err.Error())
+			return
+		}
+
+		callback(dstate, client)
+	}
+}
+
+// stateChange converts the command into a change to the state of the device.
+func (c *command) stateChange(device *tplinkModel.Device) (tplink.StateChange, error) {
+	change := tplink.StateChange{
+		Brightness: c.Brightness,
+		Hue:        c.Hue,
+		Saturation: c.Saturation,
+		ColorTemp:  c.ColorTemp,
+		Transition: c.Transition,
+	}
+
+	var isOn bool
+	switch strings.ToUpper(c.State) {
+	case "":
+		return change, nil
+	case on:
+		isOn = true
+	case off:
+		isOn = false
+	case toggle:
+		isOn = !device.State.IsOn
+	default:
+		return change, fmt.Errorf("unknown state: %s", c.State)
+	}
+	change.On = &isOn
+	return change, nil
+}
+
+// New creates a new standard listener.
+func New(options Options) listener.Listener {
+	return &Standard{options: options, logger: log.Logger, devices: make(map[string]*tplinkModel.Device)}
+}
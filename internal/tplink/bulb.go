@@ -0,0 +1,103 @@
+package tplink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+// lightSettings are the settings of a bulb which can be changed.
+type lightSettings struct {
+	Mode       string `json:"mode"`
+	Hue        int    `json:"hue"`
+	Saturation int    `json:"saturation"`
+	ColorTemp  int    `json:"color_temp"`
+	Brightness int    `json:"brightness"`
+}
+
+// lightState is the light_state reported by bulbs in system.get_sysinfo. When the bulb is off the settings which
+// will be used when it is turned back on are reported in dft_on_state instead.
+type lightState struct {
+	OnOff int `json:"on_off"`
+	lightSettings
+	DefaultOnState *lightSettings `json:"dft_on_state"`
+}
+
+// settings returns the current settings of the bulb, regardless of whether it is on.
+func (l *lightState) settings() lightSettings {
+	if l.OnOff == 0 && l.DefaultOnState != nil {
+		return *l.DefaultOnState
+	}
+	return l.lightSettings
+}
+
+// colorTempRange is the range of color temperatures a bulb model supports, in kelvin.
+type colorTempRange struct {
+	min int
+	max int
+}
+
+// colorTempRanges holds the models which don't support the default range of ColorTempDeviceAttribute.
+var colorTempRanges = map[string]colorTempRange{
+	"KL120": {min: 2700, max: 5000},
+	"KL125": {min: 2500, max: 6500},
+	"KL135": {min: 2500, max: 6500},
+	"LB120": {min: 2700, max: 6500},
+}
+
+// colorTempAttribute returns the color temperature attribute with the range supported by the model.
+func colorTempAttribute(model string) tplink.DeviceAttribute {
+	attr := tplink.ColorTempDeviceAttribute
+	for prefix, r := range colorTempRanges {
+		if strings.HasPrefix(model, prefix) {
+			attr.ValueMin = r.min
+			attr.ValueMax = r.max
+		}
+	}
+	return attr
+}
+
+// applyLightState copies the state of a bulb into the device and exposes the attributes the bulb supports.
+func applyLightState(device *tplink.Device, info *systemInfo) {
+	settings := info.LightState.settings()
+	device.State.IsOn = info.LightState.OnOff == 1
+	device.State.Brightness = settings.Brightness
+	device.Info.Type = tplink.BulbDeviceType
+	if info.Length != nil {
+		device.Info.Type = tplink.LightStripDeviceType
+	}
+
+	if info.IsDimmable == 1 {
+		device.Info.Exposes = append(device.Info.Exposes, tplink.BrightnessDeviceAttribute)
+	}
+	if info.IsColor == 1 {
+		device.State.Hue = settings.Hue
+		device.State.Saturation = settings.Saturation
+		device.Info.Exposes = append(device.Info.Exposes, tplink.HueDeviceAttribute, tplink.SaturationDeviceAttribute)
+	}
+	if info.IsVariableColorTemp == 1 {
+		device.State.ColorTemp = settings.ColorTemp
+		device.Info.Exposes = append(device.Info.Exposes, colorTempAttribute(info.Model))
+	}
+	device.Info.Exposes = append(device.Info.Exposes, tplink.TransitionDeviceAttribute)
+}
+
+// SetLightState changes the state of the bulb or light strip at the specified address.
+func (t *tplinkImpl) SetLightState(address, deviceType string, state StateChange) error {
+	var namespace, method string
+	switch deviceType {
+	case tplink.BulbDeviceType:
+		namespace, method = LightingNamespace, "transition_light_state"
+	case tplink.LightStripDeviceType:
+		namespace, method = LightStripNamespace, "set_light_state"
+	default:
+		return fmt.Errorf("unable to set light state of %s device", deviceType)
+	}
+
+	resp, err := t.SendCommand(address, NewCommand(namespace, method, state.lightArgs()))
+	if err != nil {
+		return err
+	}
+	return resp.Decode(namespace, method, nil)
+}
@@ -0,0 +1,92 @@
+package tplink
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The namespaces (or modules) which kasa devices expose commands in.
+const (
+	SystemNamespace     = "system"
+	EmeterNamespace     = "emeter"
+	ScheduleNamespace   = "schedule"
+	CountDownNamespace  = "count_down"
+	DimmerNamespace     = "smartlife.iot.dimmer"
+	LightingNamespace   = "smartlife.iot.smartbulb.lightingservice"
+	LightStripNamespace = "smartlife.iot.lightStrip"
+
+	contextKey = "context"
+)
+
+// Command is a request which can be sent to a kasa device. It maps a namespace onto the methods to call
+// within that namespace and the arguments for each method, e.g. {"system":{"get_sysinfo":{}}}.
+type Command map[string]map[string]interface{}
+
+// NewCommand creates a command which calls a single method.
+func NewCommand(namespace, method string, args interface{}) Command {
+	return Command{}.Add(namespace, method, args)
+}
+
+// Add adds a method call to the command. Devices will execute all methods in a single request.
+func (c Command) Add(namespace, method string, args interface{}) Command {
+	if args == nil {
+		args = struct{}{}
+	}
+	if c[namespace] == nil {
+		c[namespace] = make(map[string]interface{})
+	}
+	c[namespace][method] = args
+	return c
+}
+
+// WithChildren addresses the command to the specified child outlets of a power strip rather than the strip itself.
+func (c Command) WithChildren(ids ...string) Command {
+	c[contextKey] = map[string]interface{}{"child_ids": ids}
+	return c
+}
+
+// Response is the response to a command. It maps a namespace onto the results of each method which was called.
+type Response map[string]map[string]json.RawMessage
+
+// errorResult is the part of every result which indicates whether the method succeeded.
+type errorResult struct {
+	ErrorCode    int    `json:"err_code"`
+	ErrorMessage string `json:"err_msg"`
+}
+
+// Decode checks that the specified method succeeded and unmarshals the result into v. v may be nil if only
+// success needs to be checked.
+func (r Response) Decode(namespace, method string, v interface{}) error {
+	ns, ok := r[namespace]
+	if !ok {
+		return fmt.Errorf("no response for namespace %s", namespace)
+	}
+
+	raw, ok := ns[method]
+	if !ok {
+		// Unsupported namespaces are reported with an error directly on the namespace.
+		if _, ok := ns["err_code"]; ok {
+			var result errorResult
+			_ = json.Unmarshal(ns["err_code"], &result.ErrorCode)
+			_ = json.Unmarshal(ns["err_msg"], &result.ErrorMessage)
+			return fmt.Errorf("%s.%s failed: error %d: %s", namespace, method, result.ErrorCode, result.ErrorMessage)
+		}
+		return fmt.Errorf("no response for %s.%s", namespace, method)
+	}
+
+	var result errorResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return fmt.Errorf("unable to parse response for %s.%s: %w", namespace, method, err)
+	}
+	if result.ErrorCode != 0 {
+		return fmt.Errorf("%s.%s failed: error %d: %s", namespace, method, result.ErrorCode, result.ErrorMessage)
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("unable to parse response for %s.%s: %w", namespace, method, err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package tplink
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// The ways in which devices can be discovered.
+const (
+	// DiscoveryModeBroadcast sends a single get_sysinfo request to the broadcast address and waits for replies.
+	DiscoveryModeBroadcast = "broadcast"
+	// DiscoveryModeSweep probes every address in the subnet individually.
+	DiscoveryModeSweep = "sweep"
+	// DiscoveryModeBoth uses both broadcast and sweep discovery and merges the results.
+	DiscoveryModeBoth = "both"
+
+	// DefaultBroadcastAddress is the address which broadcast discovery is sent to by default.
+	DefaultBroadcastAddress = "255.255.255.255"
+)
+
+const (
+	// maxConcurrentProbes limits how many addresses are probed at once when sweeping a subnet.
+	maxConcurrentProbes = 256
+	// MinSweepPrefixLength is the shortest prefix, and so the largest subnet, which can be swept.
+	MinSweepPrefixLength = 16
+)
+
+// discover finds the addresses of all devices using the configured discovery mode.
+func (t *tplinkImpl) discover(ctx context.Context) ([]string, error) {
+	found := make(map[string]bool)
+
+	switch t.options.Discovery {
+	case DiscoveryModeBroadcast, DiscoveryModeSweep, DiscoveryModeBoth:
+	default:
+		return nil, fmt.Errorf("unknown discovery mode: %s", t.options.Discovery)
+	}
+
+	if t.options.Discovery != DiscoveryModeSweep {
+		addresses, err := t.broadcast(ctx)
+		if err != nil && t.options.Discovery == DiscoveryModeBroadcast {
+			return nil, err
+		} else if err != nil {
+			t.logger.Warn().Msgf("broadcast discovery failed, continuing with sweep: %s", err.Error())
+		}
+		for _, address := range addresses {
+			found[address] = true
+		}
+	}
+
+	if t.options.Discovery != DiscoveryModeBroadcast {
+		addresses, err := t.sweep(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			found[address] = true
+		}
+	}
+
+	addresses := make([]string, 0, len(found))
+	for address := range found {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// broadcast sends get_sysinfo to the broadcast address and returns the addresses of every device which replied.
+func (t *tplinkImpl) broadcast(ctx context.Context) ([]string, error) {
+	payload, err := json.Marshal(NewCommand(SystemNamespace, "get_sysinfo", nil))
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal command: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.options.Timeout)
+	defer cancel()
+
+	responses, err := broadcastUDP(ctx, t.options.BroadcastAddress, t.port, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(responses))
+	for address, b := range responses {
+		var resp Response
+		if err = json.Unmarshal(b, &resp); err != nil {
+			t.logger.Debug().Msgf("ignoring invalid broadcast response from %s: %s", address, err.Error())
+			continue
+		}
+		if err = resp.Decode(SystemNamespace, "get_sysinfo", nil); err != nil {
+			t.logger.Debug().Msgf("ignoring broadcast response from %s: %s", address, err.Error())
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// sweep probes every address in the subnet and returns the addresses which responded to get_sysinfo. The addresses
+// are generated as they are probed, so the whole subnet is never held in memory.
+func (t *tplinkImpl) sweep(ctx context.Context) ([]string, error) {
+	first, last, err := hostRange(t.options.Subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		addresses = make([]string, 0)
+		sem       = make(chan struct{}, maxConcurrentProbes)
+	)
+	for n := uint64(first); n <= uint64(last); n++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func(address string) {
+			defer func() { <-sem; wg.Done() }()
+			if _, err := t.sendCommand(ctx, address, NewCommand(SystemNamespace, "get_sysinfo", nil)); err != nil {
+				return
+			}
+			mu.Lock()
+			addresses = append(addresses, address)
+			mu.Unlock()
+		}(ipv4(uint32(n)).String())
+	}
+	wg.Wait()
+
+	return addresses, nil
+}
+
+// ValidateSweepSubnet checks that the subnet can be swept, which means that it must be an IPv4 subnet which is no
+// larger than a /MinSweepPrefixLength.
+func ValidateSweepSubnet(subnet string) error {
+	_, _, err := hostRange(subnet)
+	return err
+}
+
+// hostRange returns the first and last host addresses within the subnet, excluding the network and broadcast
+// addresses.
+func hostRange(subnet string) (first, last uint32, err error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid subnet specified: %w", err)
+	}
+	ip := ipnet.IP.To4()
+	ones, bits := ipnet.Mask.Size()
+	if ip == nil || bits != net.IPv4len*8 {
+		return 0, 0, fmt.Errorf("subnet %s can't be swept, only IPv4 subnets are supported", subnet)
+	}
+	if ones < MinSweepPrefixLength {
+		return 0, 0, fmt.Errorf("subnet %s is too large to sweep, it must be a /%d or smaller", subnet, MinSweepPrefixLength)
+	}
+
+	first = binary.BigEndian.Uint32(ip)
+	last = first | ^binary.BigEndian.Uint32(ipnet.Mask)
+	// Subnets which are too small to have network and broadcast addresses are swept in full.
+	if last-first >= 2 {
+		first++
+		last--
+	}
+	return first, last, nil
+}
+
+// ipv4 converts the address from its numeric form.
+func ipv4(n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
@@ -0,0 +1,69 @@
+package tplink
+
+import (
+	"github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+// emeterStat is a single entry in the response to emeter.get_daystat or emeter.get_monthstat. As with realtime
+// readings, later hardware reports in Wh rather than kWh.
+type emeterStat struct {
+	Year     int      `json:"year"`
+	Month    int      `json:"month"`
+	Day      int      `json:"day"`
+	Energy   *float32 `json:"energy"`
+	EnergyWH *float32 `json:"energy_wh"`
+}
+
+type emeterDayStats struct {
+	DayList []emeterStat `json:"day_list"`
+}
+
+type emeterMonthStats struct {
+	MonthList []emeterStat `json:"month_list"`
+}
+
+// GetDayStats returns the energy used on each day of the month which the device has a record of.
+func (t *tplinkImpl) GetDayStats(address, childID string, year, month int) ([]tplink.EnergyStat, error) {
+	var stats emeterDayStats
+	err := t.getEmeterStats(address, childID, "get_daystat", map[string]int{"year": year, "month": month}, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return toEnergyStats(stats.DayList), nil
+}
+
+// GetMonthStats returns the energy used in each month of the year which the device has a record of.
+func (t *tplinkImpl) GetMonthStats(address, childID string, year int) ([]tplink.EnergyStat, error) {
+	var stats emeterMonthStats
+	err := t.getEmeterStats(address, childID, "get_monthstat", map[string]int{"year": year}, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return toEnergyStats(stats.MonthList), nil
+}
+
+func (t *tplinkImpl) getEmeterStats(address, childID, method string, args map[string]int, v interface{}) error {
+	command := NewCommand(EmeterNamespace, method, args)
+	if childID != "" {
+		command = command.WithChildren(childID)
+	}
+
+	resp, err := t.SendCommand(address, command)
+	if err != nil {
+		return err
+	}
+	return resp.Decode(EmeterNamespace, method, v)
+}
+
+func toEnergyStats(list []emeterStat) []tplink.EnergyStat {
+	stats := make([]tplink.EnergyStat, 0, len(list))
+	for i := range list {
+		stats = append(stats, tplink.EnergyStat{
+			Year:   list[i].Year,
+			Month:  list[i].Month,
+			Day:    list[i].Day,
+			Energy: float64(normalizeUnit(list[i].Energy, list[i].EnergyWH)),
+		})
+	}
+	return stats
+}
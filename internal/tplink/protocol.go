@@ -0,0 +1,130 @@
+package tplink
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	// DefaultPort is the port which kasa devices listen on for both tcp and udp requests.
+	DefaultPort = 9999
+
+	initialKey   = 171
+	headerLength = 4
+	// maxPayloadLength guards against allocating huge buffers when a device (or something which isn't a device)
+	// responds with a corrupt header.
+	maxPayloadLength = 1 << 20
+	// maxDatagramLength is the largest response which can be received over udp.
+	maxDatagramLength = 65535
+)
+
+// encrypt obfuscates the payload using the kasa "autokey" xor cipher.
+func encrypt(payload []byte) []byte {
+	key := byte(initialKey)
+	out := make([]byte, len(payload))
+	for i, b := range payload {
+		key ^= b
+		out[i] = key
+	}
+	return out
+}
+
+// decrypt reverses the kasa "autokey" xor cipher.
+func decrypt(payload []byte) []byte {
+	key := byte(initialKey)
+	out := make([]byte, len(payload))
+	for i, b := range payload {
+		out[i] = key ^ b
+		key = b
+	}
+	return out
+}
+
+// encryptWithHeader encrypts the payload and prefixes it with its length, which is the framing used over tcp.
+func encryptWithHeader(payload []byte) []byte {
+	out := make([]byte, headerLength, headerLength+len(payload))
+	binary.BigEndian.PutUint32(out, uint32(len(payload)))
+	return append(out, encrypt(payload)...)
+}
+
+// sendTCP sends a single request to the device at the specified address and waits for the response.
+func sendTCP(ctx context.Context, address string, port int, payload []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to device: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("failed to set deadline: %w", err)
+		}
+	}
+
+	if _, err = conn.Write(encryptWithHeader(payload)); err != nil {
+		return nil, fmt.Errorf("failed to send request to device: %w", err)
+	}
+
+	header := make([]byte, headerLength)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("failed to read response header: %w", err)
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > maxPayloadLength {
+		return nil, fmt.Errorf("response of %d bytes exceeds maximum length", length)
+	}
+
+	response := make([]byte, length)
+	if _, err = io.ReadFull(conn, response); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return decrypt(response), nil
+}
+
+// broadcastUDP sends a single request to the broadcast address and collects every response which arrives before
+// the context expires. Responses are keyed by the address of the device which sent them.
+func broadcastUDP(ctx context.Context, address string, port int, payload []byte) (map[string][]byte, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open udp socket: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("failed to set deadline: %w", err)
+		}
+	}
+
+	dst, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid broadcast address: %w", err)
+	}
+
+	if _, err = conn.WriteTo(encrypt(payload), dst); err != nil {
+		return nil, fmt.Errorf("failed to send broadcast: %w", err)
+	}
+
+	responses := make(map[string][]byte)
+	buf := make([]byte, maxDatagramLength)
+	for {
+		n, src, readErr := conn.ReadFrom(buf)
+		if readErr != nil {
+			// The deadline expiring is the normal way for collection to end.
+			if ne, ok := readErr.(net.Error); ok && ne.Timeout() {
+				return responses, nil
+			}
+			return responses, fmt.Errorf("failed to read broadcast response: %w", readErr)
+		}
+		if udpAddr, ok := src.(*net.UDPAddr); ok {
+			responses[udpAddr.IP.String()] = decrypt(buf[:n])
+		}
+	}
+}
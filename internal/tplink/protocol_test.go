@@ -0,0 +1,309 @@
+package tplink
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// fakeDevice is a kasa device which listens on a local port and answers each request using handle.
+type fakeDevice struct {
+	address string
+	port    int
+	handle  func(request []byte) []byte
+
+	mu       sync.Mutex
+	requests []map[string]interface{}
+}
+
+// newFakeDevice starts a fake device which writes the bytes returned by handle in response to every request.
+func newFakeDevice(t *testing.T, handle func(request []byte) []byte) *fakeDevice {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	addr := l.Addr().(*net.TCPAddr)
+	d := &fakeDevice{address: addr.IP.String(), port: addr.Port, handle: handle}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+// newFakeDeviceWithResponse starts a fake device which responds to every request with the specified json.
+func newFakeDeviceWithResponse(t *testing.T, response string) *fakeDevice {
+	return newFakeDevice(t, func([]byte) []byte { return encryptWithHeader([]byte(response)) })
+}
+
+func (d *fakeDevice) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return
+	}
+
+	request := decrypt(payload)
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(request, &parsed); err == nil {
+		d.mu.Lock()
+		d.requests = append(d.requests, parsed)
+		d.mu.Unlock()
+	}
+	_, _ = conn.Write(d.handle(request))
+}
+
+// lastRequest returns the last request which the device received.
+func (d *fakeDevice) lastRequest(t *testing.T) map[string]interface{} {
+	t.Helper()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.requests) == 0 {
+		t.Fatal("device did not receive a request")
+	}
+	return d.requests[len(d.requests)-1]
+}
+
+// client returns a client which sends requests to the device.
+func (d *fakeDevice) client() *tplinkImpl {
+	logger := zerolog.Nop()
+	return &tplinkImpl{logger: &logger, options: Options{Timeout: time.Second}, port: d.port}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	payloads := []string{"", "{}", `{"system":{"get_sysinfo":{}}}`, strings.Repeat("kasa", 1000)}
+	for _, payload := range payloads {
+		encrypted := encrypt([]byte(payload))
+		if len(payload) > 0 && bytes.Equal(encrypted, []byte(payload)) {
+			t.Errorf("payload %q was not encrypted", payload)
+		}
+		if got := string(decrypt(encrypted)); got != payload {
+			t.Errorf("expected %q after round trip, got %q", payload, got)
+		}
+	}
+}
+
+func TestEncryptKnownValue(t *testing.T) {
+	// The first byte is xored with the initial key, and every following byte with the previous encrypted byte.
+	got := encrypt([]byte("{}"))
+	first := byte('{') ^ initialKey
+	expected := []byte{first, byte('}') ^ first}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestEncryptWithHeader(t *testing.T) {
+	payload := []byte(`{"system":{"get_sysinfo":{}}}`)
+	framed := encryptWithHeader(payload)
+
+	if len(framed) != headerLength+len(payload) {
+		t.Fatalf("expected %d bytes, got %d", headerLength+len(payload), len(framed))
+	}
+	if length := binary.BigEndian.Uint32(framed[:headerLength]); int(length) != len(payload) {
+		t.Errorf("expected header of %d, got %d", len(payload), length)
+	}
+	if got := decrypt(framed[headerLength:]); !bytes.Equal(got, payload) {
+		t.Errorf("expected %s, got %s", payload, got)
+	}
+}
+
+func TestSendTCP(t *testing.T) {
+	d := newFakeDevice(t, func(request []byte) []byte {
+		// Echo the request back so that both directions of the framing are checked.
+		return encryptWithHeader(request)
+	})
+
+	payload := []byte(`{"system":{"get_sysinfo":{}}}`)
+	got, err := sendTCP(context.Background(), d.address, d.port, payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("expected %s, got %s", payload, got)
+	}
+}
+
+func TestSendTCPMaxPayloadLength(t *testing.T) {
+	d := newFakeDevice(t, func([]byte) []byte {
+		header := make([]byte, headerLength)
+		binary.BigEndian.PutUint32(header, maxPayloadLength+1)
+		return header
+	})
+
+	_, err := sendTCP(context.Background(), d.address, d.port, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "exceeds maximum length") {
+		t.Errorf("expected maximum length error, got %v", err)
+	}
+}
+
+func TestSendTCPTruncatedResponse(t *testing.T) {
+	d := newFakeDevice(t, func([]byte) []byte {
+		// The header claims more bytes than are sent before the connection is closed.
+		return encryptWithHeader([]byte("{}"))[:headerLength+1]
+	})
+
+	if _, err := sendTCP(context.Background(), d.address, d.port, []byte("{}")); err == nil {
+		t.Error("expected error for truncated response")
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	d := newFakeDeviceWithResponse(t, `{"system":{"get_sysinfo":{"err_code":0,"alias":"Lamp","deviceId":"ABC"}}}`)
+
+	resp, err := d.client().SendCommand(d.address, NewCommand(SystemNamespace, "get_sysinfo", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	expected := map[string]interface{}{SystemNamespace: map[string]interface{}{"get_sysinfo": map[string]interface{}{}}}
+	if request := d.lastRequest(t); !reflect.DeepEqual(request, expected) {
+		t.Errorf("expected request %v, got %v", expected, request)
+	}
+
+	var info systemInfo
+	if err = resp.Decode(SystemNamespace, "get_sysinfo", &info); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if info.Alias != "Lamp" || info.DeviceID != "ABC" {
+		t.Errorf("unexpected system info: %+v", info)
+	}
+}
+
+func TestSendCommandInvalidResponse(t *testing.T) {
+	d := newFakeDeviceWithResponse(t, `not json`)
+
+	_, err := d.client().SendCommand(d.address, NewCommand(SystemNamespace, "get_sysinfo", nil))
+	if err == nil || !strings.Contains(err.Error(), "unable to parse response") {
+		t.Errorf("expected parse error, got %v", err)
+	}
+}
+
+func TestResponseDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		response  string
+		err       string
+	}{
+		{
+			name:      "success",
+			namespace: SystemNamespace,
+			response:  `{"system":{"set_relay_state":{"err_code":0}}}`,
+		},
+		{
+			name:      "namespace error",
+			namespace: ScheduleNamespace,
+			response:  `{"schedule":{"err_code":-1,"err_msg":"module not support"}}`,
+			err:       "error -1: module not support",
+		},
+		{
+			name:      "method error",
+			namespace: SystemNamespace,
+			response:  `{"system":{"set_relay_state":{"err_code":-3,"err_msg":"invalid argument"}}}`,
+			err:       "error -3: invalid argument",
+		},
+		{
+			name:      "missing namespace",
+			namespace: SystemNamespace,
+			response:  `{}`,
+			err:       "no response for namespace",
+		},
+		{
+			name:      "missing method",
+			namespace: SystemNamespace,
+			response:  `{"system":{}}`,
+			err:       "no response for system.set_relay_state",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resp Response
+			if err := json.Unmarshal([]byte(test.response), &resp); err != nil {
+				t.Fatalf("invalid test response: %s", err.Error())
+			}
+
+			err := resp.Decode(test.namespace, "set_relay_state", nil)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSetRelayState(t *testing.T) {
+	tests := []struct {
+		name     string
+		childID  string
+		on       bool
+		expected map[string]interface{}
+	}{
+		{
+			name: "plug",
+			on:   true,
+			expected: map[string]interface{}{
+				SystemNamespace: map[string]interface{}{"set_relay_state": map[string]interface{}{"state": float64(1)}},
+			},
+		},
+		{
+			name:    "outlet",
+			childID: "ABC01",
+			on:      false,
+			expected: map[string]interface{}{
+				SystemNamespace: map[string]interface{}{"set_relay_state": map[string]interface{}{"state": float64(0)}},
+				contextKey:      map[string]interface{}{"child_ids": []interface{}{"ABC01"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newFakeDeviceWithResponse(t, `{"system":{"set_relay_state":{"err_code":0}}}`)
+			if err := d.client().SetRelayState(d.address, test.childID, test.on); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if request := d.lastRequest(t); !reflect.DeepEqual(request, test.expected) {
+				t.Errorf("expected request %v, got %v", test.expected, request)
+			}
+		})
+	}
+}
+
+func TestSetRelayStateError(t *testing.T) {
+	d := newFakeDeviceWithResponse(t, `{"system":{"set_relay_state":{"err_code":-2,"err_msg":"member not support"}}}`)
+
+	err := d.client().SetRelayState(d.address, "", true)
+	if err == nil || !strings.Contains(err.Error(), "member not support") {
+		t.Errorf("expected device error, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package tplink
+
+import (
+	"fmt"
+
+	"github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+// StateChange is a change to the state of a device. Fields which are nil are left unchanged.
+type StateChange struct {
+	On         *bool
+	Brightness *int
+	Hue        *int
+	Saturation *int
+	// ColorTemp is in kelvin. Setting it to zero switches the bulb into color mode.
+	ColorTemp *int
+	// Transition is the duration of the change in milliseconds.
+	Transition *int
+}
+
+// lightArgs returns the arguments to the set light state methods of bulbs and light strips.
+func (l *StateChange) lightArgs() map[string]int {
+	args := map[string]int{"ignore_default": 1}
+	if l.On != nil {
+		args["on_off"] = 0
+		if *l.On {
+			args["on_off"] = 1
+		}
+	}
+	if l.Brightness != nil {
+		args["brightness"] = *l.Brightness
+	}
+	if l.Hue != nil {
+		args["hue"] = *l.Hue
+		// Hue and saturation are ignored unless the bulb is also taken out of color temperature mode.
+		args["color_temp"] = 0
+	}
+	if l.Saturation != nil {
+		args["saturation"] = *l.Saturation
+		args["color_temp"] = 0
+	}
+	if l.ColorTemp != nil {
+		args["color_temp"] = *l.ColorTemp
+	}
+	if l.Transition != nil {
+		args["transition_period"] = *l.Transition
+	}
+	return args
+}
+
+// SetState applies the change to the device using the commands which are appropriate for its type.
+func (t *tplinkImpl) SetState(device *tplink.Device, change StateChange) error {
+	address := device.Info.NetworkAddress
+	switch device.Info.Type {
+	case tplink.BulbDeviceType, tplink.LightStripDeviceType:
+		return t.SetLightState(address, device.Info.Type, change)
+	case tplink.DimmerDeviceType:
+		if change.Brightness != nil {
+			if err := t.SetBrightness(address, *change.Brightness); err != nil {
+				return err
+			}
+		}
+	default:
+		if change.Brightness != nil || change.Hue != nil || change.Saturation != nil || change.ColorTemp != nil {
+			return fmt.Errorf("%s devices can only be turned on and off", device.Info.Type)
+		}
+	}
+
+	if change.On == nil {
+		return nil
+	}
+	return t.SetRelayState(address, device.ChildID, *change.On)
+}
@@ -0,0 +1,104 @@
+package tplink
+
+import "strings"
+
+// systemInfo is the response to system.get_sysinfo.
+type systemInfo struct {
+	SoftwareVersion string      `json:"sw_ver"`
+	HardwareVersion string      `json:"hw_ver"`
+	Model           string      `json:"model"`
+	DeviceID        string      `json:"deviceId"`
+	OemID           string      `json:"oemId"`
+	HardwareID      string      `json:"hwId"`
+	MACAddress      string      `json:"mac"`
+	MicMACAddress   string      `json:"mic_mac"`
+	RelayState      int         `json:"relay_state"`
+	Alias           string      `json:"alias"`
+	Feature         string      `json:"feature"`
+	Children        []childInfo `json:"children"`
+	// Brightness is only reported by dimmers, and is set through the smartlife.iot.dimmer module.
+	Brightness *int `json:"brightness"`
+	// The remaining fields are only reported by bulbs and light strips.
+	LightState          *lightState `json:"light_state"`
+	IsDimmable          int         `json:"is_dimmable"`
+	IsColor             int         `json:"is_color"`
+	IsVariableColorTemp int         `json:"is_variable_color_temp"`
+	Length              *int        `json:"length"`
+}
+
+// childInfo describes an individual outlet of a power strip within system.get_sysinfo.
+type childInfo struct {
+	ID    string `json:"id"`
+	State int    `json:"state"`
+	Alias string `json:"alias"`
+}
+
+const (
+	// energyFeature is included in the feature list of devices which have an energy meter.
+	energyFeature = "ENE"
+	// childSuffixLength is the length of the child id suffix reported by firmware which omits the parent id.
+	childSuffixLength = 2
+)
+
+// hasEnergyMeter checks whether the device reports that it has an energy meter.
+func (s *systemInfo) hasEnergyMeter() bool {
+	for _, feature := range strings.Split(s.Feature, ":") {
+		if feature == energyFeature {
+			return true
+		}
+	}
+	return false
+}
+
+// macAddress returns the mac address of the device. Bulbs report it as mic_mac instead of mac.
+func (s *systemInfo) macAddress() string {
+	if s.MACAddress != "" {
+		return s.MACAddress
+	}
+	return s.MicMACAddress
+}
+
+// childID returns the fully qualified id of the child. Some firmware only reports the suffix which is appended to
+// the parent device id.
+func (s *systemInfo) childID(child childInfo) string {
+	if len(child.ID) <= childSuffixLength {
+		return s.DeviceID + child.ID
+	}
+	return child.ID
+}
+
+// emeterRealtime is the response to emeter.get_realtime. Version 1 hardware reports in V/A/W/kWh while
+// later hardware reports in mV/mA/mW/Wh, so both sets of fields are captured. The total is the energy used
+// since the counter was last reset.
+type emeterRealtime struct {
+	Current   *float32 `json:"current"`
+	CurrentMA *float32 `json:"current_ma"`
+	Voltage   *float32 `json:"voltage"`
+	VoltageMV *float32 `json:"voltage_mv"`
+	Power     *float32 `json:"power"`
+	PowerMW   *float32 `json:"power_mw"`
+	Total     *float32 `json:"total"`
+	TotalWH   *float32 `json:"total_wh"`
+}
+
+const milli = 1000
+
+// normalize returns the current, voltage and power in A, V and W regardless of hardware version.
+func (e *emeterRealtime) normalize() (current, voltage, power float32) {
+	return normalizeUnit(e.Current, e.CurrentMA), normalizeUnit(e.Voltage, e.VoltageMV), normalizeUnit(e.Power, e.PowerMW)
+}
+
+// totalEnergy returns the total energy in kWh regardless of hardware version.
+func (e *emeterRealtime) totalEnergy() float32 {
+	return normalizeUnit(e.Total, e.TotalWH)
+}
+
+func normalizeUnit(value, milliValue *float32) float32 {
+	if value != nil {
+		return *value
+	}
+	if milliValue != nil {
+		return *milliValue / milli
+	}
+	return 0
+}
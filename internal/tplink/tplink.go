@@ -2,99 +2,332 @@
 package tplink
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/shauncampbell/golang-tplink-hs100/pkg/configuration"
-	"github.com/shauncampbell/golang-tplink-hs100/pkg/hs100"
 	"github.com/shauncampbell/tplink2mqtt/pkg/tplink"
 )
 
 // TPLink collects the device state information.
 type TPLink interface {
-	CollectDeviceStates() ([]*tplink.Device, error)
+	DiscoverDevices(ctx context.Context) ([]string, error)
+	PollDeviceStates(ctx context.Context, addresses []string) <-chan *tplink.Device
 	CollectDeviceState(address string) (*tplink.Device, error)
+	SendCommand(address string, command Command) (Response, error)
+	SetRelayState(address, childID string, on bool) error
+	SetBrightness(address string, brightness int) error
+	SetLightState(address, deviceType string, state StateChange) error
+	SetState(device *tplink.Device, change StateChange) error
+	SetAlias(address, childID, alias string) error
+	GetDayStats(address, childID string, year, month int) ([]tplink.EnergyStat, error)
+	GetMonthStats(address, childID string, year int) ([]tplink.EnergyStat, error)
+}
+
+// DefaultWorkers is the number of devices which are polled at once by default.
+const DefaultWorkers = 16
+
+// Options is a struct for storing options for communicating with tplink devices.
+type Options struct {
+	// Subnet is the subnet which is swept during discovery, in CIDR notation.
+	Subnet string
+	// Timeout is how long to wait for a device to respond, and how long to collect broadcast replies for.
+	Timeout time.Duration
+	// Discovery is the discovery mode; one of DiscoveryModeBroadcast, DiscoveryModeSweep or DiscoveryModeBoth.
+	Discovery string
+	// BroadcastAddress is the address which broadcast discovery requests are sent to.
+	BroadcastAddress string
+	// Workers is the number of devices which are polled at once.
+	Workers int
 }
 
 type tplinkImpl struct {
 	logger  *zerolog.Logger
-	timeout time.Duration
-	subnet  string
+	options Options
+	port    int
 }
 
-// CollectDeviceStates collects the status of the device
-func (t *tplinkImpl) CollectDeviceStates() ([]*tplink.Device, error) {
-	logger := t.logger.With().Str("subnet", t.subnet).Dur("timeout", t.timeout).Logger()
+// DiscoverDevices finds the addresses of all devices using the configured discovery mode.
+func (t *tplinkImpl) DiscoverDevices(ctx context.Context) ([]string, error) {
+	logger := t.logger.With().Str("subnet", t.options.Subnet).Str("discovery", t.options.Discovery).
+		Dur("timeout", t.options.Timeout).Logger()
 	logger.Info().Msgf("beginning discovery")
-	devices, err := hs100.Discover(t.subnet,
-		configuration.Default().WithTimeout(t.timeout),
-	)
-
+	addresses, err := t.discover(ctx)
 	if err != nil {
-		logger.Err(err).Msgf("failed to collect device states")
+		logger.Err(err).Msgf("failed to discover devices")
 		return nil, err
 	}
 
-	logger.Info().Msgf("found %d devices", len(devices))
-	states := make([]*tplink.Device, 0)
-	for _, d := range devices {
-		state, err := t.collectDeviceState(d)
-		if err != nil {
-			logger.Error().Msgf("failed to collect device state for %s: %s", d.Address, err.Error())
-			continue
-		}
-		states = append(states, state)
+	logger.Info().Msgf("found %d devices", len(addresses))
+	return addresses, nil
+}
+
+// PollDeviceStates polls the devices at the addresses using a pool of workers. Each device has to respond before
+// its own deadline, so a device which doesn't respond only delays the worker which is polling it. Each state is sent
+// to the returned channel as soon as it has been collected, and the channel is closed once every device has been
+// polled or the context is cancelled.
+func (t *tplinkImpl) PollDeviceStates(ctx context.Context, addresses []string) <-chan *tplink.Device {
+	var (
+		wg      sync.WaitGroup
+		jobs    = make(chan string)
+		states  = make(chan *tplink.Device)
+		workers = t.options.Workers
+	)
+	if workers > len(addresses) {
+		workers = len(addresses)
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for address := range jobs {
+				deviceCtx, cancel := context.WithTimeout(ctx, t.options.Timeout)
+				state, err := t.collectDeviceState(deviceCtx, address)
+				cancel()
+				if err != nil {
+					t.logger.Error().Msgf("failed to collect device state for %s: %s", address, err.Error())
+					continue
+				}
+				select {
+				case states <- state:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
 	}
 
-	return states, nil
+	go func() {
+		defer close(jobs)
+		for _, address := range addresses {
+			select {
+			case jobs <- address:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(states)
+	}()
+	return states
+}
+
+// CollectDeviceState collects the device state for a single device.
+func (t *tplinkImpl) CollectDeviceState(address string) (*tplink.Device, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.options.Timeout)
+	defer cancel()
+	return t.collectDeviceState(ctx, address)
 }
 
-func (t *tplinkImpl) collectDeviceState(d *hs100.Hs100) (*tplink.Device, error) {
-	info, err := d.GetInfo()
+// collectDeviceState collects the device state for a single device before the context expires.
+func (t *tplinkImpl) collectDeviceState(ctx context.Context, address string) (*tplink.Device, error) {
+	resp, err := t.sendCommand(ctx, address, NewCommand(SystemNamespace, "get_sysinfo", nil).
+		Add(EmeterNamespace, "get_realtime", nil))
 	if err != nil {
+		return nil, fmt.Errorf("failed to collect device state: %w", err)
+	}
+
+	var info systemInfo
+	if err = resp.Decode(SystemNamespace, "get_sysinfo", &info); err != nil {
 		t.logger.Error().Msgf("failed to retrieve device info: %s", err.Error())
 		return nil, fmt.Errorf("failed to collect device state: %w", err)
 	}
 
 	state := &tplink.Device{
-		ID: fmt.Sprintf("0x%s", strings.ToLower(info.System.SystemInfo.DeviceID)),
+		ID: deviceID(info.DeviceID),
 		State: tplink.DeviceState{
-			IsOn: info.System.SystemInfo.RelayState == 1,
+			IsOn: info.RelayState == 1,
 		},
 		Info: tplink.DeviceInfo{
-			FriendlyName:   info.System.SystemInfo.Alias,
-			Model:          info.System.SystemInfo.Model,
-			NetworkAddress: d.Address,
+			FriendlyName:   info.Alias,
+			Model:          info.Model,
+			NetworkAddress: address,
 			Vendor:         "TPLink",
+			Type:           tplink.PlugDeviceType,
 			Exposes:        []tplink.DeviceAttribute{tplink.OnDeviceAttribute},
+			MAC:            info.macAddress(),
 		},
 	}
 
-	powerConsumption, err := d.GetCurrentPowerConsumption()
-	if err == nil {
-		state.State.Voltage = powerConsumption.Voltage
-		state.State.Power = powerConsumption.Power
-		state.State.Current = powerConsumption.Current
-		state.Info.Exposes = append(state.Info.Exposes,
-			tplink.VoltageDeviceAttribute, tplink.PowerDeviceAttribute, tplink.CurrentDeviceAttribute)
+	if info.LightState != nil {
+		// Bulbs don't have a relay or an energy meter which can be read in the same way as plugs.
+		applyLightState(state, &info)
+		return state, nil
+	}
+
+	if info.Brightness != nil {
+		state.State.Brightness = *info.Brightness
+		state.Info.Type = tplink.DimmerDeviceType
+		state.Info.Exposes = append(state.Info.Exposes, tplink.BrightnessDeviceAttribute)
+	}
+
+	if len(info.Children) > 0 {
+		// Power strips don't have a relay of their own, so everything is exposed through the children instead.
+		state.Info.Exposes = []tplink.DeviceAttribute{}
+		for _, c := range info.Children {
+			state.Children = append(state.Children, t.collectChildState(ctx, address, state, &info, c))
+		}
+		return state, nil
+	}
+
+	var realtime emeterRealtime
+	if err = resp.Decode(EmeterNamespace, "get_realtime", &realtime); err == nil {
+		applyEmeterRealtime(state, &realtime)
 	} else {
-		t.logger.Warn().Msgf("failed to collect power consumption: %s", err.Error())
+		t.logger.Debug().Msgf("failed to collect power consumption: %s", err.Error())
 	}
 	return state, nil
 }
 
-// CollectDeviceState collects the device state for a single device.
-func (t *tplinkImpl) CollectDeviceState(address string) (*tplink.Device, error) {
-	return t.collectDeviceState(hs100.NewHs100(address, configuration.Default()))
+// collectChildState builds the state of a single outlet on a power strip, including its energy meter if the strip has one.
+func (t *tplinkImpl) collectChildState(
+	ctx context.Context, address string, parent *tplink.Device, info *systemInfo, c childInfo,
+) *tplink.Device {
+	childID := info.childID(c)
+	child := &tplink.Device{
+		ID:       deviceID(childID),
+		ParentID: parent.ID,
+		ChildID:  childID,
+		State: tplink.DeviceState{
+			IsOn: c.State == 1,
+		},
+		Info: tplink.DeviceInfo{
+			FriendlyName:   c.Alias,
+			Model:          parent.Info.Model,
+			NetworkAddress: address,
+			Vendor:         parent.Info.Vendor,
+			Type:           tplink.PlugDeviceType,
+			Exposes:        []tplink.DeviceAttribute{tplink.OnDeviceAttribute},
+		},
+	}
+
+	if !info.hasEnergyMeter() {
+		return child
+	}
+
+	resp, err := t.sendCommand(ctx, address, NewCommand(EmeterNamespace, "get_realtime", nil).WithChildren(childID))
+	if err != nil {
+		t.logger.Warn().Msgf("failed to collect power consumption for outlet %s: %s", childID, err.Error())
+		return child
+	}
+
+	var realtime emeterRealtime
+	if err = resp.Decode(EmeterNamespace, "get_realtime", &realtime); err != nil {
+		t.logger.Warn().Msgf("failed to collect power consumption for outlet %s: %s", childID, err.Error())
+		return child
+	}
+	applyEmeterRealtime(child, &realtime)
+	return child
+}
+
+// applyEmeterRealtime copies the energy meter readings into the device and exposes the related attributes.
+func applyEmeterRealtime(device *tplink.Device, realtime *emeterRealtime) {
+	device.State.Current, device.State.Voltage, device.State.Power = realtime.normalize()
+	device.State.TotalEnergy = realtime.totalEnergy()
+	device.Info.Exposes = append(device.Info.Exposes,
+		tplink.VoltageDeviceAttribute, tplink.PowerDeviceAttribute, tplink.CurrentDeviceAttribute,
+		tplink.TotalEnergyDeviceAttribute)
+}
+
+func deviceID(id string) string {
+	return fmt.Sprintf("0x%s", strings.ToLower(id))
+}
+
+// SendCommand sends an arbitrary command to the device at the specified address.
+func (t *tplinkImpl) SendCommand(address string, command Command) (Response, error) {
+	return t.sendCommand(context.Background(), address, command)
+}
+
+// sendCommand sends the command to the device at the specified address, giving up when either the timeout passes
+// or the context expires.
+func (t *tplinkImpl) sendCommand(ctx context.Context, address string, command Command) (Response, error) {
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal command: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.options.Timeout)
+	defer cancel()
+
+	b, err := sendTCP(ctx, address, t.port, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp Response
+	if err = json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("unable to parse response from device: %w", err)
+	}
+	return resp, nil
+}
+
+// SetRelayState turns the device at the specified address on or off. If childID is not empty then only that
+// outlet of a power strip is switched.
+func (t *tplinkImpl) SetRelayState(address, childID string, on bool) error {
+	state := 0
+	if on {
+		state = 1
+	}
+
+	command := NewCommand(SystemNamespace, "set_relay_state", map[string]int{"state": state})
+	if childID != "" {
+		command = command.WithChildren(childID)
+	}
+
+	resp, err := t.SendCommand(address, command)
+	if err != nil {
+		return err
+	}
+	return resp.Decode(SystemNamespace, "set_relay_state", nil)
+}
+
+// SetBrightness sets the brightness of the dimmer at the specified address.
+func (t *tplinkImpl) SetBrightness(address string, brightness int) error {
+	resp, err := t.SendCommand(address, NewCommand(DimmerNamespace, "set_brightness", map[string]int{"brightness": brightness}))
+	if err != nil {
+		return err
+	}
+	return resp.Decode(DimmerNamespace, "set_brightness", nil)
+}
+
+// SetAlias sets the alias of the device at the specified address. If childID is not empty then the alias of that
+// outlet of a power strip is set instead.
+func (t *tplinkImpl) SetAlias(address, childID, alias string) error {
+	command := NewCommand(SystemNamespace, "set_dev_alias", map[string]string{"alias": alias})
+	if childID != "" {
+		command = command.WithChildren(childID)
+	}
+
+	resp, err := t.SendCommand(address, command)
+	if err != nil {
+		return err
+	}
+	return resp.Decode(SystemNamespace, "set_dev_alias", nil)
 }
 
 // New creates a new TPLink instance.
-func New(subnet string, timeout time.Duration, logger *zerolog.Logger) TPLink {
+func New(options Options, logger *zerolog.Logger) TPLink {
+	if options.Discovery == "" {
+		options.Discovery = DiscoveryModeBoth
+	}
+	if options.BroadcastAddress == "" {
+		options.BroadcastAddress = DefaultBroadcastAddress
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultWorkers
+	}
+
 	return &tplinkImpl{
 		logger:  logger,
-		timeout: timeout,
-		subnet:  subnet,
+		options: options,
+		port:    DefaultPort,
 	}
 }
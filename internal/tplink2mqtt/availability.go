@@ -0,0 +1,80 @@
+package tplink2mqtt
+
+import (
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+// availability tracks whether a device is still responding to polls.
+type availability struct {
+	lastSeen    time.Time
+	missedPolls int
+	online      bool
+}
+
+// markSeen records that the device has responded and publishes that it is available if it wasn't already.
+func (h *Handler) markSeen(device *tplinkModel.Device, client mqtt.Client) {
+	h.mu.Lock()
+	status, ok := h.availability[device.ID]
+	if !ok {
+		status = &availability{}
+		h.availability[device.ID] = status
+	}
+	status.lastSeen = time.Now()
+	status.missedPolls = 0
+	changed := !status.online
+	status.online = true
+	h.mu.Unlock()
+
+	if changed {
+		h.logger.Info().Str("device_id", device.ID).Msgf("device is online")
+		h.publishAvailability(device, true, client)
+	}
+}
+
+// checkAvailability counts a missed poll against every polled device which hasn't been seen since the poll started,
+// and publishes that a device is unavailable once it has missed too many polls in a row. Devices which have been
+// offline for longer than the offline expiry are forgotten.
+func (h *Handler) checkAvailability(pollStarted time.Time, polled map[string]bool, client mqtt.Client) {
+	offline := make([]*tplinkModel.Device, 0)
+	expired := make([]string, 0)
+
+	h.mu.Lock()
+	expiry := time.Duration(h.config.OfflineExpiry) * time.Second
+	missedPolls := h.config.MissedPolls
+	for id, status := range h.availability {
+		if !polled[id] || !status.lastSeen.Before(pollStarted) {
+			continue
+		}
+		status.missedPolls++
+		if status.online && status.missedPolls >= missedPolls {
+			status.online = false
+			if device, ok := h.devices[id]; ok {
+				offline = append(offline, device)
+			}
+		}
+		if !status.online && expiry > 0 && time.Since(status.lastSeen) > expiry {
+			expired = append(expired, id)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, device := range offline {
+		h.logger.Warn().Str("device_id", device.ID).Msgf("device is offline after %d missed polls", missedPolls)
+		h.publishAvailability(device, false, client)
+	}
+	for _, id := range expired {
+		h.logger.Warn().Str("device_id", id).Msgf("device has been offline for longer than %s, removing it", expiry)
+		h.forgetDevice(id, client)
+	}
+}
+
+func (h *Handler) publishAvailability(device *tplinkModel.Device, available bool, client mqtt.Client) {
+	for _, dest := range h.destinations {
+		if err := dest.PublishAvailability(device, available, h.trackRetained(device.ID, client)); err != nil {
+			h.logger.Error().Msgf("failed to publish availability to destination: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package tplink2mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/shauncampbell/tplink2mqtt/internal/config"
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+const (
+	bridgeRequestTopic     = "tplink2mqtt/bridge/request/#"
+	bridgeResponseTopicFmt = "tplink2mqtt/bridge/response/%s"
+	bridgeRequestMatches   = 2
+
+	statusOK    = "ok"
+	statusError = "error"
+)
+
+var bridgeRequestRegex = regexp.MustCompile(`^tplink2mqtt/bridge/request/(.+)$`)
+
+// bridgeResponse is the payload published in response to a bridge request, following the zigbee2mqtt conventions.
+type bridgeResponse struct {
+	Data        interface{} `json:"data"`
+	Status      string      `json:"status"`
+	Error       string      `json:"error,omitempty"`
+	Transaction interface{} `json:"transaction,omitempty"`
+}
+
+// bridgeOperation handles a single kind of bridge request. The payload is the raw request, and the returned data is
+// published in the response.
+type bridgeOperation func(h *Handler, client mqtt.Client, payload []byte) (interface{}, error)
+
+var bridgeOperations = map[string]bridgeOperation{
+	"device/rename":   (*Handler).renameDevice,
+	"device/remove":   (*Handler).removeDevice,
+	"device/options":  (*Handler).setDeviceOptions,
+	"restart":         (*Handler).restart,
+	"health_check":    (*Handler).healthCheck,
+	"devices/refresh": (*Handler).refreshDevices,
+}
+
+func (h *Handler) subscribeBridgeRequests(client mqtt.Client) {
+	token := client.Subscribe(bridgeRequestTopic, 1, h.handleBridgeRequest)
+	if token.Wait() && token.Error() != nil {
+		h.logger.Error().Msgf("failed to subscribe to bridge requests: %s", token.Error().Error())
+		return
+	}
+	h.logger.Info().Msgf("subscribed to %s", bridgeRequestTopic)
+}
+
+func (h *Handler) handleBridgeRequest(client mqtt.Client, message mqtt.Message) {
+	logger := h.logger.With().Str("topic", message.Topic()).Logger()
+	matches := bridgeRequestRegex.FindStringSubmatch(message.Topic())
+	if len(matches) < bridgeRequestMatches {
+		logger.Error().Msgf("unable to determine operation from topic")
+		return
+	}
+	op := matches[1]
+
+	// The transaction is optional and is echoed back in the response so that callers can match them up.
+	var request struct {
+		Transaction interface{} `json:"transaction"`
+	}
+	_ = json.Unmarshal(message.Payload(), &request)
+
+	resp := &bridgeResponse{Data: struct{}{}, Status: statusOK, Transaction: request.Transaction}
+	operation, ok := bridgeOperations[op]
+	if !ok {
+		resp.Status = statusError
+		resp.Error = fmt.Sprintf("unknown operation: %s", op)
+	} else if data, err := operation(h, client, message.Payload()); err != nil {
+		resp.Status = statusError
+		resp.Error = err.Error()
+	} else if data != nil {
+		resp.Data = data
+	}
+
+	if resp.Status == statusError {
+		logger.Error().Msgf("bridge request failed: %s", resp.Error)
+	} else {
+		logger.Info().Msgf("bridge request succeeded")
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error().Msgf("failed to create json: %s", err.Error())
+		return
+	}
+	token := client.Publish(fmt.Sprintf(bridgeResponseTopicFmt, op), 1, false, b)
+	if token.Wait() && token.Error() != nil {
+		logger.Error().Msgf("failed to publish bridge response: %s", token.Error().Error())
+	}
+}
+
+// findDevice finds a known device by its id, mac address or friendly name.
+func (h *Handler) findDevice(idOrName string) (*tplinkModel.Device, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if device, ok := h.devices[idOrName]; ok {
+		return device, nil
+	}
+	for _, device := range h.devices {
+		if device.Info.FriendlyName == idOrName || tplinkModel.SanitizeFriendlyName(device.Info.FriendlyName) == idOrName ||
+			(device.Info.MAC != "" && strings.EqualFold(device.Info.MAC, idOrName)) {
+			return device, nil
+		}
+	}
+	return nil, fmt.Errorf("device '%s' does not exist", idOrName)
+}
+
+// renameDevice sets the alias of the device itself, so the new name is also seen by the kasa app.
+func (h *Handler) renameDevice(client mqtt.Client, payload []byte) (interface{}, error) {
+	var request struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	if strings.TrimSpace(request.To) == "" {
+		return nil, fmt.Errorf("new name must not be empty")
+	}
+
+	device, err := h.findDevice(request.From)
+	if err != nil {
+		return nil, err
+	}
+
+	t := h.newTPLink()
+	if err = t.SetAlias(device.Info.NetworkAddress, device.ChildID, request.To); err != nil {
+		return nil, fmt.Errorf("failed to set alias: %w", err)
+	}
+
+	// An overridden friendly name would hide the new alias, so it is updated to match.
+	h.mu.Lock()
+	if key, options, ok := h.config.Device(device.ID, device.Info.MAC); ok && options.FriendlyName != "" {
+		patch, _ := json.Marshal(map[string]string{"friendly_name": request.To})
+		_, _ = h.overrideDevice(key, options, patch)
+	}
+	h.mu.Unlock()
+
+	updated, err := t.CollectDeviceState(device.Info.NetworkAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect device state: %w", err)
+	}
+	h.refreshDeviceStatus(updated, client)
+	h.publishBridgeInfo(client)
+
+	return map[string]string{"from": device.Info.FriendlyName, "to": request.To}, nil
+}
+
+// removeDevice stops tracking the device and clears its retained topics. Devices can't be unpaired, so unless it is
+// also blocked it will be found again by the next discovery if it is still on the network.
+func (h *Handler) removeDevice(client mqtt.Client, payload []byte) (interface{}, error) {
+	var request struct {
+		ID    string `json:"id"`
+		Block bool   `json:"block"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	device, err := h.findDevice(request.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Block {
+		h.mu.Lock()
+		key, options, ok := h.config.Device(device.ID, device.Info.MAC)
+		if !ok {
+			key = device.ID
+		}
+		_, _ = h.overrideDevice(key, options, []byte(`{"ignore":true}`))
+		h.mu.Unlock()
+	}
+	h.forgetDevice(device.ID, client)
+	h.publishBridgeInfo(client)
+
+	return map[string]interface{}{"id": device.ID, "block": request.Block}, nil
+}
+
+// setDeviceOptions changes the options of a device. Only the options which are present in the request are changed.
+func (h *Handler) setDeviceOptions(client mqtt.Client, payload []byte) (interface{}, error) {
+	var request struct {
+		ID      string          `json:"id"`
+		Options json.RawMessage `json:"options"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	// Devices which have been ignored are no longer known, so they have to be referred to by id or mac address.
+	id, mac := request.ID, ""
+	if device, err := h.findDevice(request.ID); err == nil {
+		id, mac = device.ID, device.Info.MAC
+	}
+
+	h.mu.Lock()
+	key, from, ok := h.config.Device(id, mac)
+	if !ok {
+		key = id
+	}
+	to, err := h.overrideDevice(key, from, request.Options)
+	if err != nil {
+		h.mu.Unlock()
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	// Options such as the home assistant entity options aren't part of the device, so it is published again in
+	// case they have changed.
+	delete(h.lastPublished, id)
+	h.mu.Unlock()
+	h.publishBridgeInfo(client)
+
+	return map[string]interface{}{"id": key, "from": from, "to": to}, nil
+}
+
+// restart restarts the discovery and polling loops.
+func (h *Handler) restart(client mqtt.Client, _ []byte) (interface{}, error) {
+	h.start(client)
+	return nil, nil
+}
+
+// healthCheck returns the health of the bridge, which is also published to BridgeHealthTopic.
+func (h *Handler) healthCheck(_ mqtt.Client, _ []byte) (interface{}, error) {
+	return h.bridgeHealth(), nil
+}
+
+// refreshDevices triggers discovery and polls every known device immediately rather than waiting for the next
+// interval.
+func (h *Handler) refreshDevices(_ mqtt.Client, _ []byte) (interface{}, error) {
+	select {
+	case h.refresh <- struct{}{}:
+	default:
+		// A refresh is already pending.
+	}
+	h.registry.pollAll()
+	h.wakePoller()
+	return nil, nil
+}
+
+// overrideDevice applies the patch, which holds the options to change as json, to the options of the device with the
+// specified key. The patch is applied again whenever the configuration is reloaded, so that the change isn't lost.
+// The caller must hold h.mu.
+func (h *Handler) overrideDevice(key string, options config.DeviceOptions, patch []byte) (config.DeviceOptions, error) {
+	if err := json.Unmarshal(patch, &options); err != nil {
+		return options, err
+	}
+	h.config.Devices[key] = options
+	h.overrides[key] = append(h.overrides[key], patch)
+	return options, nil
+}
+
+// applyDeviceOptions applies the options for the device to it. It returns false if the device is ignored.
+func (h *Handler) applyDeviceOptions(device *tplinkModel.Device) bool {
+	h.mu.Lock()
+	_, options, ok := h.config.Device(device.ID, device.Info.MAC)
+	h.mu.Unlock()
+	if !ok {
+		return true
+	}
+	if options.Ignore {
+		return false
+	}
+	if options.FriendlyName != "" {
+		device.Info.FriendlyName = options.FriendlyName
+	}
+	return true
+}
+
+// HomeAssistantOptions returns the options which customise the home assistant entity of the device.
+func (h *Handler) HomeAssistantOptions(device *tplinkModel.Device) config.HomeAssistantOptions {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, options, _ := h.config.Device(device.ID, device.Info.MAC)
+	return options.HomeAssistant
+}
@@ -0,0 +1,49 @@
+package tplink2mqtt
+
+import (
+	"math"
+
+	"github.com/shauncampbell/tplink2mqtt/internal/config"
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+// stateChanged checks whether the state of the device has changed since it was last published. Changes to numeric
+// attributes which are within their deadband are ignored, so that noisy readings aren't published on every poll.
+// The caller must hold h.mu.
+func (h *Handler) stateChanged(last, device *tplinkModel.Device) bool {
+	if last.State.IsEqualTo(device.State) {
+		return false
+	}
+	if last.State.IsOn != device.State.IsOn {
+		return true
+	}
+
+	for i := range device.Info.Exposes {
+		property := device.Info.Exposes[i].Property
+		current, ok := device.State.NumericValue(property)
+		if !ok {
+			continue
+		}
+		previous, _ := last.State.NumericValue(property)
+		if exceedsDeadband(previous, current, h.config.Deadband[property]) {
+			return true
+		}
+	}
+	return false
+}
+
+// exceedsDeadband checks whether the change from previous to current exceeds every threshold of the deadband. A
+// deadband without any thresholds is exceeded by any change.
+func exceedsDeadband(previous, current float64, deadband config.Deadband) bool {
+	change := math.Abs(current - previous)
+	if change == 0 {
+		return false
+	}
+	if deadband.Absolute > 0 && change < deadband.Absolute {
+		return false
+	}
+	if deadband.Percent > 0 && previous != 0 && change/math.Abs(previous)*100 < deadband.Percent {
+		return false
+	}
+	return true
+}
@@ -2,6 +2,9 @@
 package tplink2mqtt
 
 import (
+	"context"
+	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/shauncampbell/tplink2mqtt/internal/destination"
@@ -16,56 +19,289 @@ import (
 	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
 )
 
+// BridgeStateTopic is the topic which the state of the bridge is published to. It is also the topic of the last
+// will and testament, so subscribers will see the bridge go offline if the connection is lost unexpectedly.
+const BridgeStateTopic = "tplink2mqtt/bridge/state"
+
 // Handler handles zigbee2mqtt messages
 type Handler struct {
 	config       *config.Config
-	stopped      bool
 	logger       zerolog.Logger
 	devices      map[string]*tplinkModel.Device
+	availability map[string]*availability
+	// lastPublished holds when each device was last published, and devices holds the device as it was published.
+	lastPublished map[string]time.Time
+	// retained holds the retained topics which have been published for each device.
+	retained     map[string]map[string]struct{}
 	destinations []destination.Destination
 	listeners    []listener.Listener
+	mu           sync.Mutex
+	// health holds the health of each component of the bridge.
+	health map[string]*componentHealth
+	// registry holds the addresses of the devices which have been discovered.
+	registry *registry
+	// cancel stops the discovery and polling loops, if they are running.
+	cancel context.CancelFunc
+	// refresh triggers an immediate discovery rather than waiting for the next interval.
+	refresh chan struct{}
+	// wake wakes the polling loop when devices become due early, e.g. when new devices have been discovered.
+	wake chan struct{}
+	// lastDiscovery is when the last discovery started, and lastDiscoveryDuration is how long it took.
+	lastDiscovery         time.Time
+	lastDiscoveryDuration time.Duration
+	// overrides holds the changes to device options which have been made through the bridge, keyed like
+	// config.Devices, so that they survive reloading the configuration.
+	overrides map[string][]json.RawMessage
 }
 
 // Connected is a handler which is called when the initial connection to the mqtt server is established.
 func (h *Handler) Connected(client mqtt.Client) {
-	h.stopped = false
-	go h.publishDeviceList(client)
+	// The broker may have lost the retained messages while disconnected, so everything is published again.
+	h.mu.Lock()
+	h.lastPublished = make(map[string]time.Time)
+	for _, status := range h.availability {
+		status.online = false
+	}
+	h.mu.Unlock()
+
+	h.publishBridgeState(client)
+	h.publishBridgeInfo(client)
+	h.publishBridgeHealth(client)
+	h.subscribeBridgeRequests(client)
+	h.start(client)
 }
 
 // Disconnected is a handler which is called when the connection to the mqtt server is severed.
 func (h *Handler) Disconnected(client mqtt.Client, err error) {
-	h.stopped = true
+	h.stop()
+}
+
+// start starts the discovery and polling loops, stopping the existing loops first if there are any.
+func (h *Handler) start(client mqtt.Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cancel != nil {
+		h.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	go h.supervise(ctx, discoveryComponent, client, h.discoverDevices)
+	go h.supervise(ctx, pollingComponent, client, h.pollDevices)
+}
+
+// stop stops the discovery and polling loops.
+func (h *Handler) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+}
+
+// newTPLink creates a client for communicating with devices using the current configuration.
+func (h *Handler) newTPLink() tplink.TPLink {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return tplink.New(tplink.Options{
+		Subnet:           h.config.Subnet,
+		Timeout:          time.Second * time.Duration(h.config.Timeout),
+		Discovery:        h.config.Discovery,
+		BroadcastAddress: h.config.Broadcast,
+		Workers:          h.config.Workers,
+	}, &log.Logger)
+}
+
+func (h *Handler) publishBridgeState(client mqtt.Client) {
+	token := client.Publish(BridgeStateTopic, 1, true, destination.AvailabilityPayload(true))
+	if token.Wait() && token.Error() != nil {
+		h.logger.Error().Msgf("failed to publish bridge state: %s", token.Error().Error())
+	}
 }
 
-func (h *Handler) publishDeviceList(client mqtt.Client) {
+// discoverDevices periodically discovers devices and adds them to the registry so that their state is polled. If
+// discovery fails it is retried with an exponential backoff, and the failure is reported on the health topic.
+func (h *Handler) discoverDevices(ctx context.Context, client mqtt.Client) {
+	failures := 0
 	for {
-		tpClient := tplink.New(h.config.Subnet, time.Second*time.Duration(h.config.Timeout), &log.Logger)
-		devices, err := tpClient.CollectDeviceStates()
+		started := time.Now()
+		addresses, err := h.newTPLink().DiscoverDevices(ctx)
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			h.logger.Error().Msgf("failed to collect device states: %s", err.Error())
+			failures++
+			wait := backoff(failures)
+			h.logger.Error().Msgf("failed to discover devices, retrying in %s: %s", wait, err.Error())
+			h.setHealth(discoveryComponent, err, client)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-h.refresh:
+			case <-time.After(wait):
+			}
+			continue
+		}
+		failures = 0
+		h.recordDiscovery(started)
+		h.setHealth(discoveryComponent, nil, client)
+
+		if added := h.registry.add(addresses); added > 0 {
+			h.logger.Info().Msgf("discovered %d new devices", added)
+			h.wakePoller()
+		}
+		h.publishBridgeInfo(client)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.refresh:
+		case <-time.After(h.discoveryInterval()):
+		}
+	}
+}
+
+// wakePoller wakes the polling loop, so that it polls any devices which have become due.
+func (h *Handler) wakePoller() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+		// The polling loop is already due to wake up.
+	}
+}
+
+// pollDevices polls the state of each device in the registry whenever it is due, until the context is cancelled.
+func (h *Handler) pollDevices(ctx context.Context, client mqtt.Client) {
+	for {
+		if addresses := h.registry.due(time.Now()); len(addresses) > 0 {
+			h.pollAddresses(ctx, addresses, client)
+			if ctx.Err() != nil {
+				return
+			}
+			h.setHealth(pollingComponent, nil, client)
+		}
+
+		// With no devices there is nothing to wait for until discovery finds some.
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if next := h.registry.next(); !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			wait = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-h.wake:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
 			return
 		}
+	}
+}
+
+// pollAddresses polls the devices at the addresses and publishes them as soon as they respond.
+func (h *Handler) pollAddresses(ctx context.Context, addresses []string, client mqtt.Client) {
+	pollStarted := time.Now()
+	count := h.deviceCount()
 
-		for _, device := range devices {
-			h.publishDeviceStatus(device, client)
+	for device := range h.newTPLink().PollDeviceStates(ctx, addresses) {
+		h.registry.identify(device.Info.NetworkAddress, deviceIDs(device))
+		h.publishDeviceStatus(device, client)
+	}
+	if ctx.Err() != nil {
+		// The poll was cut short, so devices which weren't reached haven't really been missed.
+		return
+	}
+
+	polled := make(map[string]bool)
+	for _, address := range addresses {
+		ids := h.registry.ids(address)
+		for _, id := range ids {
+			polled[id] = true
 		}
+		h.registry.schedule(address, pollStarted.Add(h.pollInterval(ids)))
+	}
+	h.checkAvailability(pollStarted, polled, client)
 
-		time.Sleep(time.Duration(h.config.Interval) * time.Second)
+	if h.deviceCount() != count {
+		h.publishBridgeInfo(client)
+	}
+}
+
+// pollInterval returns how often the devices with the ids are polled. If any of them override the poll interval
+// then the shortest override is used.
+func (h *Handler) pollInterval(ids []string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	interval := 0
+	for _, id := range ids {
+		mac := ""
+		if device, ok := h.devices[id]; ok {
+			mac = device.Info.MAC
+		}
+		if _, options, ok := h.config.Device(id, mac); ok && options.PollInterval > 0 {
+			if interval == 0 || options.PollInterval < interval {
+				interval = options.PollInterval
+			}
+		}
+	}
+	if interval == 0 {
+		interval = h.config.Interval
 	}
+	return time.Duration(interval) * time.Second
+}
+
+// discoveryInterval returns how often devices are discovered.
+func (h *Handler) discoveryInterval() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Duration(h.config.DiscoveryInterval) * time.Second
 }
 
+// deviceCount returns how many devices have been published.
+func (h *Handler) deviceCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.devices)
+}
+
+// publishDeviceStatus publishes the device if it has changed since it was last published.
 func (h *Handler) publishDeviceStatus(device *tplinkModel.Device, client mqtt.Client) {
-	var err error
-	for _, dest := range h.destinations {
-		err = dest.Publish(device, client)
-		if err != nil {
-			h.logger.Error().Msgf("failed to publish to destination: %s", err.Error())
-			continue
+	h.updateDeviceStatus(device, false, client)
+}
+
+// refreshDeviceStatus publishes the state of the device even if it hasn't changed. It is used by the listeners,
+// since a request for the state of a device should always be answered.
+func (h *Handler) refreshDeviceStatus(device *tplinkModel.Device, client mqtt.Client) {
+	h.updateDeviceStatus(device, true, client)
+}
+
+func (h *Handler) updateDeviceStatus(device *tplinkModel.Device, force bool, client mqtt.Client) {
+	// Power strips are published as one switch per outlet rather than as a single device.
+	if len(device.Children) > 0 {
+		for _, child := range device.Children {
+			h.updateDeviceStatus(child, force, client)
 		}
+		return
+	}
+
+	if !h.applyDeviceOptions(device) {
+		return
+	}
+
+	configChanged, stateChanged := h.changes(device)
+	if configChanged || stateChanged || force {
+		h.publish(device, configChanged, client)
 	}
+	h.markSeen(device, client)
 
 	for _, list := range h.listeners {
-		err = list.Listen(device, client, h.publishDeviceStatus)
+		err := list.Listen(device, client, h.refreshDeviceStatus)
 		if err != nil {
 			h.logger.Error().Msgf("failed to subscribe to listener: %s", err.Error())
 			continue
@@ -76,9 +312,17 @@ func (h *Handler) publishDeviceStatus(device *tplinkModel.Device, client mqtt.Cl
 // New creates a new handler.
 func New(cfg *config.Config, destinations []destination.Destination, listeners []listener.Listener) *Handler {
 	return &Handler{
-		devices:      make(map[string]*tplinkModel.Device),
-		destinations: destinations,
-		listeners:    listeners,
-		logger:       log.Logger,
-		config:       cfg}
+		devices:       make(map[string]*tplinkModel.Device),
+		availability:  make(map[string]*availability),
+		retained:      make(map[string]map[string]struct{}),
+		lastPublished: make(map[string]time.Time),
+		health:        make(map[string]*componentHealth),
+		overrides:     make(map[string][]json.RawMessage),
+		registry:      newRegistry(),
+		refresh:       make(chan struct{}, 1),
+		wake:          make(chan struct{}, 1),
+		destinations:  destinations,
+		listeners:     listeners,
+		logger:        log.Logger,
+		config:        cfg}
 }
@@ -0,0 +1,148 @@
+package tplink2mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// BridgeHealthTopic is the topic which the health of the bridge is published to.
+const BridgeHealthTopic = "tplink2mqtt/bridge/health"
+
+// The components of the bridge whose health is reported.
+const (
+	discoveryComponent = "discovery"
+	pollingComponent   = "polling"
+)
+
+const (
+	// initialBackoff is how long to wait before retrying after the first failure.
+	initialBackoff = 5 * time.Second
+	// maxBackoff is the longest time to wait before retrying, however many failures there have been.
+	maxBackoff = 5 * time.Minute
+)
+
+// componentHealth is the health of a single component of the bridge.
+type componentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Failures is the number of times in a row that the component has failed.
+	Failures    int        `json:"consecutive_failures"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// bridgeHealth is the payload published to BridgeHealthTopic and returned by the health_check request.
+type bridgeHealth struct {
+	Healthy    bool                       `json:"healthy"`
+	Components map[string]componentHealth `json:"components"`
+}
+
+// backoff returns how long to wait before retrying after the specified number of failures in a row.
+func backoff(failures int) time.Duration {
+	wait := initialBackoff
+	for i := 1; i < failures && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// setHealth records whether the component succeeded, and publishes the health of the bridge if it has changed.
+func (h *Handler) setHealth(component string, err error, client mqtt.Client) {
+	h.mu.Lock()
+	health, ok := h.health[component]
+	if !ok {
+		health = &componentHealth{}
+		h.health[component] = health
+	}
+	previous := *health
+	if err != nil {
+		health.Status = statusError
+		health.Error = err.Error()
+		health.Failures++
+	} else {
+		now := time.Now()
+		health.Status = statusOK
+		health.Error = ""
+		health.Failures = 0
+		health.LastSuccess = &now
+	}
+	changed := previous.Status != health.Status || previous.Failures != health.Failures
+	h.mu.Unlock()
+
+	if changed {
+		h.publishBridgeHealth(client)
+	}
+}
+
+// bridgeHealth returns the health of the bridge. It is only healthy if every component is.
+func (h *Handler) bridgeHealth() *bridgeHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := &bridgeHealth{Healthy: true, Components: make(map[string]componentHealth)}
+	for component, c := range h.health {
+		health.Components[component] = *c
+		if c.Status != statusOK {
+			health.Healthy = false
+		}
+	}
+	return health
+}
+
+func (h *Handler) publishBridgeHealth(client mqtt.Client) {
+	b, err := json.Marshal(h.bridgeHealth())
+	if err != nil {
+		h.logger.Error().Msgf("failed to create json: %s", err.Error())
+		return
+	}
+	token := client.Publish(BridgeHealthTopic, 1, true, b)
+	if token.Wait() && token.Error() != nil {
+		h.logger.Error().Msgf("failed to publish bridge health: %s", token.Error().Error())
+	}
+}
+
+// supervise runs the loop until the context is cancelled. If the loop stops or panics before then, it is reported
+// on the health topic and restarted after a backoff.
+func (h *Handler) supervise(ctx context.Context, component string, client mqtt.Client, loop func(context.Context, mqtt.Client)) {
+	failures := 0
+	for {
+		started := time.Now()
+		err := runLoop(ctx, client, loop)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("%s stopped unexpectedly", component)
+		}
+		// A loop which ran for a while before failing isn't failing repeatedly, so the backoff starts again.
+		if time.Since(started) > maxBackoff {
+			failures = 0
+		}
+		failures++
+		wait := backoff(failures)
+		h.logger.Error().Msgf("%s failed, restarting in %s: %s", component, wait, err.Error())
+		h.setHealth(component, err, client)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runLoop runs the loop, converting a panic into an error.
+func runLoop(ctx context.Context, client mqtt.Client, loop func(context.Context, mqtt.Client)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	loop(ctx, client)
+	return nil
+}
@@ -0,0 +1,66 @@
+package tplink2mqtt
+
+import (
+	"encoding/json"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/shauncampbell/tplink2mqtt/internal/config"
+	"github.com/shauncampbell/tplink2mqtt/internal/version"
+)
+
+// BridgeInfoTopic is the topic which information about the running bridge is published to.
+const BridgeInfoTopic = "tplink2mqtt/bridge/info"
+
+// bridgeInfo is the payload published to BridgeInfoTopic.
+type bridgeInfo struct {
+	Version     string        `json:"version"`
+	Config      config.Config `json:"config"`
+	Discovery   discoveryInfo `json:"discovery"`
+	DeviceCount int           `json:"device_count"`
+}
+
+// discoveryInfo describes how devices are discovered and how long it took the last time.
+type discoveryInfo struct {
+	Mode           string     `json:"mode"`
+	LastRun        *time.Time `json:"last_run,omitempty"`
+	LastDurationMs int64      `json:"last_duration_ms"`
+}
+
+// recordDiscovery stores when the last discovery started and how long it took.
+func (h *Handler) recordDiscovery(started time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastDiscovery = started
+	h.lastDiscoveryDuration = time.Since(started)
+}
+
+// publishBridgeInfo publishes the version and configuration of the bridge. It is published whenever the
+// configuration changes and after each discovery.
+func (h *Handler) publishBridgeInfo(client mqtt.Client) {
+	h.mu.Lock()
+	info := &bridgeInfo{
+		Version: version.Version,
+		Config:  h.config.Redacted(),
+		Discovery: discoveryInfo{
+			Mode:           h.config.Discovery,
+			LastDurationMs: h.lastDiscoveryDuration.Milliseconds(),
+		},
+		DeviceCount: len(h.devices),
+	}
+	if !h.lastDiscovery.IsZero() {
+		lastRun := h.lastDiscovery
+		info.Discovery.LastRun = &lastRun
+	}
+	h.mu.Unlock()
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		h.logger.Error().Msgf("failed to create json: %s", err.Error())
+		return
+	}
+	token := client.Publish(BridgeInfoTopic, 1, true, b)
+	if token.Wait() && token.Error() != nil {
+		h.logger.Error().Msgf("failed to publish bridge info: %s", token.Error().Error())
+	}
+}
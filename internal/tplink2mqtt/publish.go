@@ -0,0 +1,60 @@
+package tplink2mqtt
+
+import (
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+// changes compares the device with the one which was last published, and reports whether its configuration and
+// state need to be published. Both are published again once the republish interval has passed, in case they were
+// lost by the broker.
+func (h *Handler) changes(device *tplinkModel.Device) (configChanged, stateChanged bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last, ok := h.devices[device.ID]
+	if !ok {
+		return true, true
+	}
+	published, ok := h.lastPublished[device.ID]
+	if !ok {
+		return true, true
+	}
+	interval := time.Duration(h.config.RepublishInterval) * time.Second
+	if interval > 0 && time.Since(published) >= interval {
+		return true, true
+	}
+
+	// A change to the configuration can move the state topics, so the state is published with it.
+	configChanged = !last.Info.IsEqualTo(&device.Info)
+	return configChanged, configChanged || h.stateChanged(last, device)
+}
+
+// publish publishes the device to every destination. The device is only recorded as published if every destination
+// succeeded, so that failures are retried on the next poll.
+func (h *Handler) publish(device *tplinkModel.Device, configChanged bool, client mqtt.Client) {
+	tracked := h.trackRetained(device.ID, client)
+	failed := false
+	for _, dest := range h.destinations {
+		if configChanged {
+			if err := dest.PublishConfig(device, tracked); err != nil {
+				h.logger.Error().Msgf("failed to publish config to destination: %s", err.Error())
+				failed = true
+				continue
+			}
+		}
+		if err := dest.PublishState(device, tracked); err != nil {
+			h.logger.Error().Msgf("failed to publish state to destination: %s", err.Error())
+			failed = true
+		}
+	}
+	if failed {
+		return
+	}
+
+	h.mu.Lock()
+	h.devices[device.ID] = device
+	h.lastPublished[device.ID] = time.Now()
+	h.mu.Unlock()
+}
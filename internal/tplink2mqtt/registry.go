@@ -0,0 +1,161 @@
+package tplink2mqtt
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+// registryEntry is an address which a device has been discovered at.
+type registryEntry struct {
+	// ids are the ids of the devices at the address, which are only known once it has been polled. Power strips
+	// have one for the strip itself and one for each outlet.
+	ids []string
+	// nextPoll is when the address is next due to be polled.
+	nextPoll time.Time
+}
+
+// registry holds the addresses of the devices which have been discovered, so that their state can be polled without
+// discovering them again.
+type registry struct {
+	mu      sync.Mutex
+	entries map[string]*registryEntry
+}
+
+func newRegistry() *registry {
+	return &registry{entries: make(map[string]*registryEntry)}
+}
+
+// add adds the addresses which aren't already known, and returns how many were added. New addresses are due to be
+// polled immediately.
+func (r *registry) add(addresses []string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	added := 0
+	for _, address := range addresses {
+		if _, ok := r.entries[address]; !ok {
+			r.entries[address] = &registryEntry{}
+			added++
+		}
+	}
+	return added
+}
+
+// identify records the ids of the devices at the address. If the device was previously known at another address
+// then it has moved, so the old address is removed.
+func (r *registry) identify(address string, ids []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[address]
+	if !ok {
+		entry = &registryEntry{}
+		r.entries[address] = entry
+	}
+	entry.ids = ids
+
+	for other, e := range r.entries {
+		if other != address && containsAny(e.ids, ids) {
+			delete(r.entries, other)
+		}
+	}
+}
+
+// schedule sets when the address is next due to be polled.
+func (r *registry) schedule(address string, next time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.entries[address]; ok {
+		entry.nextPoll = next
+	}
+}
+
+// pollAll makes every address due to be polled immediately.
+func (r *registry) pollAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range r.entries {
+		entry.nextPoll = time.Time{}
+	}
+}
+
+// due returns the addresses which are due to be polled.
+func (r *registry) due(now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addresses := make([]string, 0)
+	for address, entry := range r.entries {
+		if !entry.nextPoll.After(now) {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// next returns when the next address is due to be polled, or the zero time if there are no addresses.
+func (r *registry) next() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var next time.Time
+	for _, entry := range r.entries {
+		if next.IsZero() || entry.nextPoll.Before(next) {
+			next = entry.nextPoll
+		}
+	}
+	return next
+}
+
+// ids returns the ids of the devices at the address.
+func (r *registry) ids(address string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.entries[address]; ok {
+		return entry.ids
+	}
+	return nil
+}
+
+// removeDevice stops tracking the device. The address is only removed, so that it is no longer polled, once none of
+// the devices at it are tracked, otherwise removing one outlet of a power strip would stop the others being polled.
+func (r *registry) removeDevice(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for address, entry := range r.entries {
+		if !containsAny(entry.ids, []string{id}) {
+			continue
+		}
+		ids := make([]string, 0, len(entry.ids))
+		for _, other := range entry.ids {
+			if other != id {
+				ids = append(ids, other)
+			}
+		}
+		if len(ids) == 0 {
+			delete(r.entries, address)
+			continue
+		}
+		entry.ids = ids
+	}
+}
+
+// deviceIDs returns the id of the device and the ids of its children.
+func deviceIDs(device *tplinkModel.Device) []string {
+	ids := []string{device.ID}
+	for _, child := range device.Children {
+		ids = append(ids, child.ID)
+	}
+	return ids
+}
+
+func containsAny(ids, other []string) bool {
+	for _, a := range ids {
+		for _, b := range other {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package tplink2mqtt
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/shauncampbell/tplink2mqtt/internal/config"
+)
+
+// Reload applies a new configuration without disconnecting from mqtt. The discovery and polling loops are restarted
+// with the new settings, the listeners subscribe again and every device is published again so that changes to
+// friendly names are applied. Settings which can only be applied by restarting are logged. Device options which
+// have been set through the bridge take precedence over the new configuration.
+func (h *Handler) Reload(cfg *config.Config, client mqtt.Client) {
+	h.mu.Lock()
+	h.applyOverrides(cfg)
+	previous := h.config
+	if reflect.DeepEqual(previous, cfg) {
+		h.mu.Unlock()
+		h.logger.Debug().Msgf("configuration has not changed")
+		return
+	}
+	h.config = cfg
+	// Renamed devices have new topics, so their availability is published again along with everything else.
+	h.lastPublished = make(map[string]time.Time)
+	for _, status := range h.availability {
+		status.online = false
+	}
+	h.mu.Unlock()
+
+	for _, setting := range restartRequired(previous, cfg) {
+		h.logger.Warn().Msgf("%s has changed, but it won't be applied until tplink2mqtt is restarted", setting)
+	}
+
+	for _, list := range h.listeners {
+		if err := list.Reset(client); err != nil {
+			h.logger.Error().Msgf("failed to reset listener: %s", err.Error())
+		}
+	}
+	h.registry.pollAll()
+
+	// If the connection has been lost the loops are started again once it has been re-established.
+	if client.IsConnected() {
+		h.publishBridgeInfo(client)
+		h.start(client)
+	}
+	h.logger.Info().Msgf("configuration reloaded")
+}
+
+// applyOverrides applies the changes to device options which have been made through the bridge to the
+// configuration. The caller must hold h.mu.
+func (h *Handler) applyOverrides(cfg *config.Config) {
+	for key, patches := range h.overrides {
+		// The configuration may refer to the device differently, e.g. by its mac address rather than its id.
+		existing, options, ok := cfg.Device(key, "")
+		if ok {
+			delete(cfg.Devices, existing)
+		}
+		for _, patch := range patches {
+			if err := json.Unmarshal(patch, &options); err != nil {
+				h.logger.Error().Msgf("failed to apply options of device %s: %s", key, err.Error())
+			}
+		}
+		cfg.Devices[key] = options
+		h.logger.Debug().Msgf("applied the options of device %s which were set through the bridge", key)
+	}
+}
+
+// restartRequired returns the settings which have changed but can't be applied without restarting.
+func restartRequired(previous, cfg *config.Config) []string {
+	settings := make([]string, 0)
+	if !reflect.DeepEqual(previous.MQTT, cfg.MQTT) {
+		settings = append(settings, "the mqtt connection")
+	}
+	if previous.Timeout != cfg.Timeout {
+		// The listeners are created with the timeout when the application starts.
+		settings = append(settings, "the timeout of commands received over mqtt")
+	}
+	return settings
+}
@@ -0,0 +1,75 @@
+package tplink2mqtt
+
+import (
+	"sort"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	tplinkModel "github.com/shauncampbell/tplink2mqtt/pkg/tplink"
+)
+
+// bridgeTopicPrefix is the prefix of topics which are shared by all devices, so they are never tracked for a device.
+const bridgeTopicPrefix = "tplink2mqtt/bridge/"
+
+// retainedClient is an mqtt.Client which records the retained topics published through it for a device, so they
+// can be cleared when the device goes away.
+type retainedClient struct {
+	mqtt.Client
+	handler  *Handler
+	deviceID string
+}
+
+// Publish publishes the message and records the topic if it is retained.
+func (r *retainedClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if retained && !strings.HasPrefix(topic, bridgeTopicPrefix) {
+		r.handler.mu.Lock()
+		topics, ok := r.handler.retained[r.deviceID]
+		if !ok {
+			topics = make(map[string]struct{})
+			r.handler.retained[r.deviceID] = topics
+		}
+		topics[topic] = struct{}{}
+		r.handler.mu.Unlock()
+	}
+	return r.Client.Publish(topic, qos, retained, payload)
+}
+
+// trackRetained returns a client which records the retained topics published for the device.
+func (h *Handler) trackRetained(deviceID string, client mqtt.Client) mqtt.Client {
+	return &retainedClient{Client: client, handler: h, deviceID: deviceID}
+}
+
+// forgetDevice stops tracking the device and clears every retained topic which has been published for it, so that
+// the discovery config and state are deleted from the broker. The device is also removed from the destinations.
+func (h *Handler) forgetDevice(deviceID string, client mqtt.Client) {
+	h.mu.Lock()
+	device, ok := h.devices[deviceID]
+	if !ok {
+		device = &tplinkModel.Device{ID: deviceID}
+	}
+	delete(h.devices, deviceID)
+	delete(h.availability, deviceID)
+	delete(h.lastPublished, deviceID)
+	topics := make([]string, 0, len(h.retained[deviceID]))
+	for topic := range h.retained[deviceID] {
+		topics = append(topics, topic)
+	}
+	delete(h.retained, deviceID)
+	h.mu.Unlock()
+	h.registry.removeDevice(deviceID)
+
+	sort.Strings(topics)
+	for _, topic := range topics {
+		h.logger.Info().Str("device_id", deviceID).Msgf("clearing retained topic %s", topic)
+		token := client.Publish(topic, 1, true, []byte{})
+		if token.Wait() && token.Error() != nil {
+			h.logger.Error().Msgf("failed to clear retained topic %s: %s", topic, token.Error().Error())
+		}
+	}
+
+	for _, dest := range h.destinations {
+		if err := dest.Remove(device, client); err != nil {
+			h.logger.Error().Str("device_id", deviceID).Msgf("failed to remove device: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,6 @@
+// Package version contains the version of the application.
+package version
+
+// Version is the version of the application. It is injected at build time with
+// -ldflags "-X github.com/shauncampbell/tplink2mqtt/internal/version.Version=<version>".
+var Version = "dev"
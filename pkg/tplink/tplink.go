@@ -1,13 +1,27 @@
 // Package tplink contains model structs
 package tplink
 
+import "strings"
+
+// The types of device which are supported.
+const (
+	PlugDeviceType       = "plug"
+	DimmerDeviceType     = "dimmer"
+	BulbDeviceType       = "bulb"
+	LightStripDeviceType = "light_strip"
+)
+
 // DeviceInfo represents mostly static information about the device.
 type DeviceInfo struct {
 	FriendlyName   string            `json:"friendly_name"`
 	Model          string            `json:"model"`
 	NetworkAddress string            `json:"network_address"`
 	Vendor         string            `json:"vendor"`
+	Type           string            `json:"type"`
 	Exposes        []DeviceAttribute `json:"exposes"`
+	// MAC is the mac address of the device. It isn't set for the outlets of power strips, which share the mac
+	// address of the strip.
+	MAC string `json:"mac,omitempty"`
 }
 
 // IsEqualTo checks that this object is equal to another.
@@ -27,7 +41,29 @@ func (di *DeviceInfo) IsEqualTo(info *DeviceInfo) bool {
 	return di.FriendlyName == info.FriendlyName &&
 		di.Model == info.Model &&
 		di.NetworkAddress == info.NetworkAddress &&
-		di.Vendor == info.Vendor
+		di.Vendor == info.Vendor &&
+		di.Type == info.Type &&
+		di.MAC == info.MAC
+}
+
+// HasAttribute checks whether the device exposes the attribute with the specified property.
+func (di *DeviceInfo) HasAttribute(property string) bool {
+	return di.Attribute(property) != nil
+}
+
+// Attribute returns the exposed attribute with the specified property, or nil if it isn't exposed.
+func (di *DeviceInfo) Attribute(property string) *DeviceAttribute {
+	for i := range di.Exposes {
+		if di.Exposes[i].Property == property {
+			return &di.Exposes[i]
+		}
+	}
+	return nil
+}
+
+// IsLight checks whether the device is a light, i.e. a dimmer, bulb or light strip.
+func (di *DeviceInfo) IsLight() bool {
+	return di.Type == DimmerDeviceType || di.Type == BulbDeviceType || di.Type == LightStripDeviceType
 }
 
 // DeviceAttribute is an attribute which the device exposes to the user.
@@ -102,12 +138,92 @@ var CurrentDeviceAttribute = DeviceAttribute{
 	ValueMin:    0,
 }
 
+// TotalEnergyDeviceAttribute is the attribute for the cumulative energy used.
+var TotalEnergyDeviceAttribute = DeviceAttribute{
+	Access:      1,
+	Description: "Total energy used",
+	Name:        "total_energy",
+	Property:    "total_energy",
+	Type:        "numeric",
+	Unit:        "kWh",
+	ValueMax:    1000000,
+	ValueMin:    0,
+}
+
+// BrightnessDeviceAttribute is the attribute for the brightness of a dimmer.
+var BrightnessDeviceAttribute = DeviceAttribute{
+	Access:      1,
+	Description: "Brightness of the light",
+	Name:        "brightness",
+	Property:    "brightness",
+	Type:        "numeric",
+	Unit:        "%",
+	ValueMax:    100,
+	ValueMin:    0,
+}
+
+// HueDeviceAttribute is the attribute for the hue of a color bulb.
+var HueDeviceAttribute = DeviceAttribute{
+	Access:      1,
+	Description: "Hue of the light",
+	Name:        "hue",
+	Property:    "hue",
+	Type:        "numeric",
+	Unit:        "°",
+	ValueMax:    360,
+	ValueMin:    0,
+}
+
+// SaturationDeviceAttribute is the attribute for the saturation of a color bulb.
+var SaturationDeviceAttribute = DeviceAttribute{
+	Access:      1,
+	Description: "Saturation of the light",
+	Name:        "saturation",
+	Property:    "saturation",
+	Type:        "numeric",
+	Unit:        "%",
+	ValueMax:    100,
+	ValueMin:    0,
+}
+
+// ColorTempDeviceAttribute is the attribute for the color temperature of a bulb. The range varies by model, so
+// devices may expose a copy with a narrower range.
+var ColorTempDeviceAttribute = DeviceAttribute{
+	Access:      1,
+	Description: "Color temperature of the light",
+	Name:        "color_temp",
+	Property:    "color_temp",
+	Type:        "numeric",
+	Unit:        "K",
+	ValueMax:    9000,
+	ValueMin:    2500,
+}
+
+// TransitionDeviceAttribute is the attribute for the transition time used when a bulb changes state. It can only
+// be set and is never reported.
+var TransitionDeviceAttribute = DeviceAttribute{
+	Access:      2,
+	Description: "Duration of the transition when changing state",
+	Name:        "transition",
+	Property:    "transition",
+	Type:        "numeric",
+	Unit:        "ms",
+	ValueMax:    10000,
+	ValueMin:    0,
+}
+
 // DeviceState represents information about the device which changes.
 type DeviceState struct {
 	IsOn    bool    `json:"is_on"`
 	Current float32 `json:"current"`
 	Power   float32 `json:"power"`
 	Voltage float32 `json:"voltage"`
+	// TotalEnergy is the cumulative energy used in kWh.
+	TotalEnergy float32 `json:"total_energy"`
+	Brightness  int     `json:"brightness"`
+	Hue         int     `json:"hue"`
+	Saturation  int     `json:"saturation"`
+	ColorTemp   int     `json:"color_temp"`
 }
 
 // IsEqualTo checks that this object is equal to another.
@@ -115,7 +231,37 @@ func (ds *DeviceState) IsEqualTo(deviceState DeviceState) bool {
 	return ds.IsOn == deviceState.IsOn &&
 		ds.Current == deviceState.Current &&
 		ds.Power == deviceState.Power &&
-		ds.Voltage == deviceState.Voltage
+		ds.Voltage == deviceState.Voltage &&
+		ds.TotalEnergy == deviceState.TotalEnergy &&
+		ds.Brightness == deviceState.Brightness &&
+		ds.Hue == deviceState.Hue &&
+		ds.Saturation == deviceState.Saturation &&
+		ds.ColorTemp == deviceState.ColorTemp
+}
+
+// NumericValue returns the value of the numeric attribute with the specified property. The second return value
+// is false if the property isn't a numeric attribute.
+func (ds *DeviceState) NumericValue(property string) (float64, bool) {
+	switch property {
+	case VoltageDeviceAttribute.Property:
+		return float64(ds.Voltage), true
+	case CurrentDeviceAttribute.Property:
+		return float64(ds.Current), true
+	case PowerDeviceAttribute.Property:
+		return float64(ds.Power), true
+	case TotalEnergyDeviceAttribute.Property:
+		return float64(ds.TotalEnergy), true
+	case BrightnessDeviceAttribute.Property:
+		return float64(ds.Brightness), true
+	case HueDeviceAttribute.Property:
+		return float64(ds.Hue), true
+	case SaturationDeviceAttribute.Property:
+		return float64(ds.Saturation), true
+	case ColorTempDeviceAttribute.Property:
+		return float64(ds.ColorTemp), true
+	default:
+		return 0, false
+	}
 }
 
 // Device represents the hs1xx device.
@@ -123,9 +269,45 @@ type Device struct {
 	ID    string      `json:"id"`
 	State DeviceState `json:"state"`
 	Info  DeviceInfo  `json:"info"`
+	// ParentID is the id of the device which this device is an outlet of, if any.
+	ParentID string `json:"parent_id,omitempty"`
+	// ChildID is the id which the parent device uses to address this outlet.
+	ChildID string `json:"child_id,omitempty"`
+	// Children are the individually controllable outlets of a power strip.
+	Children []*Device `json:"children,omitempty"`
 }
 
 // IsEqualTo checks that this object is equal to another.
 func (d *Device) IsEqualTo(device *Device) bool {
-	return d.ID == device.ID && d.State.IsEqualTo(device.State) && d.Info.IsEqualTo(&device.Info)
+	if len(d.Children) != len(device.Children) {
+		return false
+	}
+	for i := range d.Children {
+		if !d.Children[i].IsEqualTo(device.Children[i]) {
+			return false
+		}
+	}
+
+	return d.ID == device.ID &&
+		d.ParentID == device.ParentID &&
+		d.ChildID == device.ChildID &&
+		d.State.IsEqualTo(device.State) &&
+		d.Info.IsEqualTo(&device.Info)
+}
+
+// SanitizeFriendlyName converts a friendly name into the form which is used in mqtt topics.
+func SanitizeFriendlyName(friendlyName string) string {
+	str := strings.ToLower(friendlyName)
+	str = strings.ReplaceAll(str, " ", "_")
+	return str
+}
+
+// EnergyStat is the energy used during a single day or month.
+type EnergyStat struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+	// Day is only set for daily statistics.
+	Day int `json:"day,omitempty"`
+	// Energy is the energy used in kWh.
+	Energy float64 `json:"energy"`
 }